@@ -1,9 +1,12 @@
 package clippan
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net/url"
+	"os"
 	"strings"
 
 	_ "github.com/go-kivik/couchdb/v4"
@@ -18,29 +21,63 @@ type Printer interface {
 	Print(string, ...interface{})
 	JSON([]byte)
 }
+
+// ProgressPrinter is implemented by Printers that can show progress on a
+// line that is overwritten by the next progress
+type ProgressPrinter interface {
+	Progress(string, ...interface{})
+}
+
+// TextPrinter prints to a writer, optionally colorizing JSON output
 type TextPrinter struct {
-	debug bool
+	out      io.Writer
+	color    bool
+	debug    bool
+	progress bool // if a progress line needs to be ended
+}
+
+func NewTextPrinter(out io.Writer, color, debug bool) *TextPrinter {
+	return &TextPrinter{out: out, color: color, debug: debug}
+}
+
+// Progress shows progress on stderr, so it doesn't end up in redirected or
+// paged output. The line is ended by the next other output
+func (p *TextPrinter) Progress(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "\r"+format, args...)
+	p.progress = true
+}
+
+func (p *TextPrinter) endProgress() {
+	if p.progress {
+		fmt.Fprintln(os.Stderr)
+		p.progress = false
+	}
 }
 
 func (p *TextPrinter) Error(format string, args ...interface{}) {
+	p.endProgress()
 	fmt.Printf("ERROR: "+format+"\n", args...)
 }
 
 func (p *TextPrinter) Debug(format string, args ...interface{}) {
 	if p.debug {
+		p.endProgress()
 		fmt.Printf("DEBUG: "+format+"\n", args...)
 	}
 }
 
 func (p *TextPrinter) Print(format string, args ...interface{}) {
-	fmt.Printf(format+"\n", args...)
+	p.endProgress()
+	fmt.Fprintf(p.out, format+"\n", args...)
 }
 
 func (p *TextPrinter) JSON(raw []byte) {
+	p.endProgress()
 	data := pretty.Pretty(raw)
-	// can be optional if we want color / more control over formatting
-	data = pretty.Color(data, nil)
-	fmt.Println(string(data))
+	if p.color {
+		data = pretty.Color(data, nil)
+	}
+	fmt.Fprintln(p.out, string(data))
 }
 
 type Clippan struct {
@@ -54,8 +91,12 @@ type Clippan struct {
 	Prompt  Prompter
 	//
 	enableWrite bool
+	debug       bool
+	pager       string
 	host        string
 	db          string // database.Name() ??
+	partitioned bool   // if database is a partitioned database
+	listing     *Listing
 }
 
 func NewClippan(dsn string, enableWrite, debug bool) *Clippan {
@@ -74,9 +115,11 @@ func NewClippan(dsn string, enableWrite, debug bool) *Clippan {
 		db:          database,
 		client:      nil,
 		enableWrite: enableWrite,
+		debug:       debug,
+		pager:       PagerAuto,
 		host:        u.Host,
 		Prompt:      nil,
-		Printer:     &TextPrinter{debug},
+		Printer:     NewTextPrinter(os.Stdout, true, debug),
 		Editor:      editor,
 	}
 }
@@ -97,6 +140,13 @@ func (c *Clippan) JSON(raw []byte) {
 	c.Printer.JSON(raw)
 }
 
+// Progress shows progress if the Printer supports it
+func (c *Clippan) Progress(format string, args ...interface{}) {
+	if p, ok := c.Printer.(ProgressPrinter); ok {
+		p.Progress(format, args...)
+	}
+}
+
 func (c *Clippan) Connect() error {
 	if c.client != nil {
 		c.client.Close(context.TODO())
@@ -110,7 +160,12 @@ func (c *Clippan) Connect() error {
 }
 
 func (c *Clippan) Executer(s string) {
-	parsed, err := shellwords.Parse(s)
+	pipeline, err := ParsePipeline(s)
+	if err != nil {
+		c.Error(err.Error())
+		return
+	}
+	parsed, err := shellwords.Parse(pipeline.Command)
 	if err != nil {
 		c.Error(err.Error())
 		return
@@ -119,25 +174,87 @@ func (c *Clippan) Executer(s string) {
 		return
 	}
 	c.Debug("Command: %#v", parsed)
-	cmd := parsed[0]
 
-	found := false
+	orig := c.Printer
+	defer func() { c.Printer = orig }()
+
+	var out io.Writer = os.Stdout
+	if pipeline.Redirect != "" {
+		f, err := pipeline.OpenRedirect()
+		if err != nil {
+			c.Error(err.Error())
+			return
+		}
+		defer f.Close()
+		out = f
+		// Files should contain clean output, without colors
+		c.Printer = NewTextPrinter(f, false, c.debug)
+	}
+
+	var buf *bytes.Buffer
+	if pipeline.Shell != "" {
+		// Output for external programs should not contain colors
+		buf = &bytes.Buffer{}
+		c.Printer = NewTextPrinter(buf, false, c.debug)
+	}
+
+	ce := FindCommand(parsed[0])
+	var pager *PagerPrinter
+	if pipeline.Redirect == "" && buf == nil && ce != nil && ce.flags&Paged == Paged &&
+		(c.pager == PagerOn || c.pager == PagerAuto) {
+		pager = NewPagerPrinter(c.pager, c.debug)
+		c.Printer = pager
+	}
+	if len(pipeline.Filters) > 0 {
+		c.Printer = NewFilterPrinter(c.Printer, pipeline.Filters)
+	}
+	c.execute(ce, parsed)
+
+	if pager != nil {
+		c.Printer = orig
+		if err := pager.Flush(); err != nil {
+			c.Error(err.Error())
+		}
+	}
+	if buf != nil {
+		c.Printer = orig
+		if err := runShell(pipeline.Shell, buf.Bytes(), out); err != nil {
+			c.Error(err.Error())
+		}
+	}
+}
+
+// FindCommand returns the command with the given name, or nil if there is none
+func FindCommand(cmd string) *Command {
 	for _, ce := range Commands {
 		if ce.cmd == cmd {
-			if ce.writeOp && !c.enableWrite {
-				c.Error("Write operation in ro mode. Restart with `-write`")
-			} else if ce.flags&NeedConnection == NeedConnection && c.client == nil {
-				c.Error("Not connected")
-			} else if ce.flags&NeedDatabase == NeedDatabase && c.database == nil {
-				c.Error("No database selected")
-			} else if err := ce.handler(c, parsed); err != nil {
-				c.Error(err.Error())
-			}
-			found = true
+			return ce
 		}
 	}
-	if !found {
+	return nil
+}
+
+// execute runs a single, parsed command
+func (c *Clippan) execute(ce *Command, parsed []string) {
+	if ce == nil {
 		c.Error("command not found. Use 'help'")
+	} else if ce.writeOp && !c.enableWrite {
+		c.Error(ReadOnlyError.Error())
+	} else if ce.flags&NeedConnection == NeedConnection && c.client == nil {
+		c.Error("Not connected")
+	} else if ce.flags&NeedDatabase == NeedDatabase && c.database == nil {
+		c.Error("No database selected")
+	} else if err := ce.handler(c, parsed); err != nil {
+		c.Error(err.Error())
+	}
+}
+
+// Flush makes sure any output buffered by the Printer is shown
+func (c *Clippan) Flush() {
+	if f, ok := c.Printer.(Flusher); ok {
+		if err := f.Flush(); err != nil {
+			c.Error(err.Error())
+		}
 	}
 }
 
@@ -156,6 +273,8 @@ func (c *Clippan) UseDB(db string) bool {
 
 	c.db = db
 	c.database = c.client.DB(context.TODO(), db)
+	c.partitioned = IsPartitioned(c.database)
+	c.listing = nil
 	mode := "(ro)"
 	if c.enableWrite {
 		mode = "(rw)"
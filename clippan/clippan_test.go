@@ -1,7 +1,12 @@
 package clippan
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/iivvoo/clippan/helpers"
@@ -9,10 +14,11 @@ import (
 )
 
 type TestPrinter struct {
-	Errors []string // possibly preserve format and args separately
-	Debugs []string
-	Prints []string
-	JSONS  [][]byte
+	Errors     []string // possibly preserve format and args separately
+	Debugs     []string
+	Prints     []string
+	JSONS      [][]byte
+	Progresses []string
 }
 
 func (t *TestPrinter) Error(format string, args ...interface{}) {
@@ -27,6 +33,9 @@ func (t *TestPrinter) Print(format string, args ...interface{}) {
 func (t *TestPrinter) JSON(raw []byte) {
 	t.JSONS = append(t.JSONS, raw)
 }
+func (t *TestPrinter) Progress(format string, args ...interface{}) {
+	t.Progresses = append(t.Progresses, fmt.Sprintf(format, args...))
+}
 
 type MockEditor struct {
 	received []byte
@@ -70,6 +79,10 @@ func (m *MockPrompt) Input(s string) string {
 	m.Inputs = append(m.Inputs, s)
 	return m.result
 }
+func (m *MockPrompt) Password(s string) string {
+	m.Inputs = append(m.Inputs, s)
+	return m.result
+}
 func NewTestClippan(testdb *helpers.CouchDB, enableWrite bool, printer Printer, editor Editor, prompt Prompter) *Clippan {
 	return &Clippan{
 		dsn:         "",
@@ -123,3 +136,49 @@ func TestRun(t *testing.T) {
 	}))
 
 }
+
+func TestTextPrinter(t *testing.T) {
+	t.Run("Test color", func(t *testing.T) {
+		assert := assert.New(t)
+		buf := &bytes.Buffer{}
+		p := NewTextPrinter(buf, true, false)
+		p.JSON([]byte(`{"a":1}`))
+		assert.Contains(buf.String(), "\x1b[")
+	})
+	t.Run("Test no color", func(t *testing.T) {
+		assert := assert.New(t)
+		buf := &bytes.Buffer{}
+		p := NewTextPrinter(buf, false, false)
+		p.Print("hello %s", "world")
+		p.JSON([]byte(`{"a":1}`))
+		assert.Equal("hello world\n{\n  \"a\": 1\n}\n\n", buf.String())
+	})
+}
+
+func TestRedirect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clippan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("Test redirect and append", func(t *testing.T) {
+		assert := assert.New(t)
+		p := &TestPrinter{}
+		c := &Clippan{Printer: p}
+		out := filepath.Join(dir, "help.txt")
+
+		c.Executer("help > " + out)
+		data, err := ioutil.ReadFile(out)
+		assert.NoError(err)
+		assert.Contains(string(data), "Show help")
+		assert.NotContains(string(data), "\x1b[")
+		assert.Len(p.Prints, 0)
+
+		c.Executer("help >> " + out)
+		appended, err := ioutil.ReadFile(out)
+		assert.NoError(err)
+		assert.Equal(2, strings.Count(string(appended), "Show help"))
+		assert.Len(p.Prints, 0)
+	})
+}
@@ -0,0 +1,224 @@
+package clippan
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Nodes shows the cluster membership, highlighting nodes that are only
+// connected or only configured
+func Nodes(c *Clippan, args []string) error {
+	membership, err := c.client.Membership(context.TODO())
+	if err != nil {
+		return err
+	}
+	connected := map[string]bool{}
+	for _, n := range membership.AllNodes {
+		connected[n] = true
+	}
+	configured := map[string]bool{}
+	for _, n := range membership.ClusterNodes {
+		configured[n] = true
+	}
+	nodes := mergeNodes(membership.AllNodes, membership.ClusterNodes)
+
+	mismatches := 0
+	c.Print("%-40s %-10s %-10s", "Node", "Connected", "Configured")
+	for _, n := range nodes {
+		c.Print("%-40s %-10t %-10t", n, connected[n], configured[n])
+		if connected[n] != configured[n] {
+			mismatches++
+		}
+	}
+	if mismatches > 0 {
+		c.Error("%d node(s) are not both connected (all_nodes) and configured (cluster_nodes)", mismatches)
+	}
+	return nil
+}
+
+// mergeNodes returns the sorted union of lists of node names
+func mergeNodes(lists ...[]string) []string {
+	seen := map[string]bool{}
+	var nodes []string
+	for _, list := range lists {
+		for _, n := range list {
+			if !seen[n] {
+				seen[n] = true
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// nodeURL derives the url of a node from its name (e.g. couchdb@10.0.0.1),
+// assuming it's reachable using the same scheme, port and credentials as the
+// current connection. Single node setups are called nonode@nohost
+func nodeURL(server *url.URL, node string) *url.URL {
+	host := node
+	if i := strings.Index(node, "@"); i >= 0 {
+		host = node[i+1:]
+	}
+	u := *server
+	if host != "nohost" {
+		if port := server.Port(); port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+		u.Host = host
+	}
+	return &u
+}
+
+// upStatus checks a single node's _up endpoint
+func upStatus(c *Clippan, server *url.URL) string {
+	var up struct {
+		Status string `json:"status"`
+	}
+	err := c.RequestURL(server, http.MethodGet, "/_up", nil, &up)
+	if err != nil {
+		if httpErr, ok := err.(*HTTPError); ok {
+			return fmt.Sprintf("down (%d %s)", httpErr.Status, http.StatusText(httpErr.Status))
+		}
+		return "unreachable: " + err.Error()
+	}
+	return up.Status
+}
+
+// Up checks the health of the server or, with -all, of all cluster nodes
+func Up(c *Clippan, args []string) error {
+	var all bool
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: up [-all]\n")
+		fmt.Fprintf(os.Stderr, "With -all, nodes are assumed to be reachable on their name's host, using the current port and credentials\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&all, "all", false, "Check all cluster nodes")
+	if fs.Parse(args[1:]) != nil {
+		return nil // help will have been printed
+	}
+
+	server, err := c.ServerURL()
+	if err != nil {
+		return err
+	}
+	if !all {
+		c.Print("%s: %s", server.Host, upStatus(c, server))
+		return nil
+	}
+
+	membership, err := c.client.Membership(context.TODO())
+	if err != nil {
+		return err
+	}
+	nodes := mergeNodes(membership.AllNodes, membership.ClusterNodes)
+	ok := 0
+	for _, n := range nodes {
+		status := upStatus(c, nodeURL(server, n))
+		if status == "ok" {
+			ok++
+		}
+		c.Print("%-40s %s", n, status)
+	}
+	c.Print("%d/%d nodes up", ok, len(nodes))
+	return nil
+}
+
+// statValue returns the value of a counter in the _stats tree, e.g. couchdb/httpd/requests
+func statValue(stats map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = stats
+	for _, p := range strings.Split(path, "/") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[p]; !ok {
+			return nil, false
+		}
+	}
+	if m, ok := current.(map[string]interface{}); ok {
+		current, ok = m["value"]
+		return current, ok
+	}
+	return current, true
+}
+
+// Node shows the system and request statistics of a node
+func Node(c *Clippan, args []string) error {
+	var useJson bool
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: node [flags] [name]\n")
+		fmt.Fprintf(os.Stderr, "Shows the node clippan is connected to if no name is given, see `nodes` for names\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&useJson, "json", false, "Output json")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(positional) > 1 {
+		fs.Usage()
+		return UsageError
+	}
+	node := localNode
+	if len(positional) == 1 {
+		node = positional[0]
+	}
+	base := "/_node/" + url.PathEscape(node)
+
+	var system, stats map[string]interface{}
+	if err := c.Get(base+"/_system", &system); err != nil {
+		return err
+	}
+	if err := c.Get(base+"/_stats", &stats); err != nil {
+		return err
+	}
+	if useJson {
+		c.JSON(MustMarshal(map[string]interface{}{"system": system, "stats": stats}))
+		return nil
+	}
+
+	c.Print("%s", node)
+	if uptime, ok := system["uptime"].(float64); ok {
+		c.Print("  uptime:        %s", time.Duration(uptime)*time.Second)
+	}
+	if memory, ok := system["memory"].(map[string]interface{}); ok {
+		var parts []string
+		for _, k := range []string{"processes", "binary", "ets", "code", "other"} {
+			if v, ok := memory[k].(float64); ok {
+				parts = append(parts, fmt.Sprintf("%s %s", k, FormatSize(int64(v))))
+			}
+		}
+		c.Print("  memory:        %s", strings.Join(parts, ", "))
+	}
+	c.Print("  processes:     %v of %v", system["process_count"], system["process_limit"])
+	c.Print("  run queue:     %v", system["run_queue"])
+	c.Print("  os processes:  %v (%v stale)", system["os_proc_count"], system["stale_proc_count"])
+
+	if v, ok := statValue(stats, "couchdb/httpd/requests"); ok {
+		c.Print("  requests:      %v", v)
+	}
+	couchdb, _ := stats["couchdb"].(map[string]interface{})
+	if codes, ok := couchdb["httpd_status_codes"].(map[string]interface{}); ok {
+		var parts []string
+		for _, code := range sortedKeys(codes) {
+			if v, ok := statValue(codes, code); ok && v != 0.0 {
+				parts = append(parts, fmt.Sprintf("%s: %v", code, v))
+			}
+		}
+		c.Print("  status codes:  %s", strings.Join(parts, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package clippan
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterHelpers(t *testing.T) {
+	t.Run("Test nodeURL", func(t *testing.T) {
+		assert := assert.New(t)
+		server, _ := url.Parse("http://admin:pw@localhost:5984")
+		assert.Equal("http://admin:pw@10.0.0.2:5984", nodeURL(server, "couchdb@10.0.0.2").String())
+		assert.Equal("http://admin:pw@localhost:5984", nodeURL(server, "nonode@nohost").String())
+	})
+	t.Run("Test mergeNodes", func(t *testing.T) {
+		assert.Equal(t, []string{"a", "b", "c"}, mergeNodes([]string{"b", "a"}, []string{"c", "a"}))
+	})
+	t.Run("Test statValue", func(t *testing.T) {
+		assert := assert.New(t)
+		var stats map[string]interface{}
+		MustUnmarshal([]byte(`{"couchdb": {"httpd": {"requests": {"value": 12, "type": "counter"}}}}`), &stats)
+		v, ok := statValue(stats, "couchdb/httpd/requests")
+		assert.True(ok)
+		assert.Equal(12.0, v)
+		_, ok = statValue(stats, "couchdb/nope/requests")
+		assert.False(ok)
+	})
+}
+
+func TestCluster(t *testing.T) {
+	DB := helpers.DBSession("test-cluster")
+
+	t.Run("Test nodes, up and node", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("nodes")
+		c.Executer("up")
+		c.Executer("node")
+		assert.Len(printer.Errors, 0)
+		output := strings.Join(printer.Prints, "\n")
+		assert.Contains(output, "Connected")
+		assert.Contains(output, ": ok")
+		assert.Contains(output, "uptime:")
+	}))
+}
@@ -8,9 +8,12 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
 	"strings"
 
+	"github.com/go-kivik/couchdb/v4"
 	"github.com/go-kivik/kivik/v4"
 	"github.com/gobwas/glob"
 	"github.com/iivvoo/clippan/helpers"
@@ -21,8 +24,9 @@ type Flag uint8
 
 const (
 	None           Flag = 0
-	NeedConnection Flag = iota << 1
+	NeedConnection Flag = 1 << iota
 	NeedDatabase
+	Paged // output may be shown through a pager
 )
 
 type Command struct {
@@ -38,20 +42,57 @@ var NoDatabaseError = errors.New("Not connected to a database")
 var DocumentNotFoundError = errors.New("Document not found")
 var DatabaseExists = errors.New("Database already exists")
 var DatabaseDoesNotExist = errors.New("Database does not exist")
+var NoListingError = errors.New("Nothing to continue, use `all` first")
+var ReadOnlyError = errors.New("Write operation in ro mode. Restart with `-write`")
 
 var Commands []*Command
 
 func init() {
 	Commands = []*Command{
 		{"use", "Connect to a database (takes just a database name or a full dsn)", false, NeedConnection, UseDB},
-		{"databases", "List all databases", false, NeedConnection, Databases},
-		{"createdb", "Create a database", true, NeedConnection, CreateDB},
+		{"databases", "List all databases", false, NeedConnection | Paged, Databases},
+		{"info", "Show information about the current or given database", false, NeedConnection, Info},
+		{"shards", "Show the shards of a database, or which shard a document -id is in", false, NeedConnection, Shards},
+		{"sync_shards", "Synchronize the shard replicas of a database", true, NeedConnection, SyncShards},
+		{"partition", "Show information about a partition of a partitioned database", false, NeedDatabase, Partition},
+		{"createdb", "Create databases, with -q, -n, -partitioned, -from <db> (see createdb -h)", true, NeedConnection, CreateDB},
 		{"deletedb", "Delete a database", true, NeedConnection, DeleteDB},
-		{"all", "List all docs, paginated", false, NeedDatabase, AllDocs},
-		{"get", "Get a single document by id", false, NeedDatabase, Get},
+		{"all", "List all docs, paginated", false, NeedDatabase | Paged, AllDocs},
+		{"next", "Show the next page of the last `all`", false, NeedDatabase | Paged, Next},
+		{"prev", "Show the previous page of the last `all`", false, NeedDatabase | Paged, Prev},
+		{"get", "Get a single document by id", false, NeedDatabase | Paged, Get},
 		{"put", "Create a new document", true, NeedDatabase, Put},
 		{"edit", "Edit an existing document", true, NeedDatabase, Edit},
-		{"query", "Query a view", false, NeedDatabase, Query},
+		{"cp", "Copy a document: cp [-f] <source> <target>, optionally across databases (db/id)", true, NeedConnection, Cp},
+		{"mv", "Move a document: mv [-f] <source> <target>, optionally across databases (db/id)", true, NeedConnection, Mv},
+		{"diff", "Compare documents (id1 id2, db1/id db2/id) or revisions (id -rev a -rev b)", false, NeedConnection | Paged, Diff},
+		{"query", "Query a view", false, NeedDatabase | Paged, Query},
+		{"ddocs", "List design documents", false, NeedDatabase | Paged, DesignDocs},
+		{"ddoc", "Manage design documents: ddoc show|edit|push|pull, see ddoc -h", false, NeedDatabase, DesignDoc},
+		{"mkview", "Create or edit a view", true, NeedDatabase, MakeView},
+		{"testview", "Run a map/reduce function locally on sample documents", false, NeedDatabase, TestView},
+		{"viewinfo", "Show the index info of a design document, -wait for it to be built", false, NeedDatabase, ViewInfo},
+		{"replicate", "Replicate a database: replicate <source> <target>", true, NeedConnection, Replicate},
+		{"replications", "List replications and their state", false, NeedConnection | Paged, Replications},
+		{"cancelrep", "Cancel a replication by document or job id", true, NeedConnection, CancelReplication},
+		{"copydb", "Copy (a subset of) a database in batches, resumable: copydb <source> <target>", true, NeedConnection, CopyDB},
+		{"dbdiff", "Compare the documents in two databases: dbdiff <dbA> <dbB>, -content to compare bodies", false, NeedConnection | Paged, DBDiff},
+		{"tasks", "List active tasks on the server, optionally -watch them", false, NeedConnection, Tasks},
+		{"compact", "Compact databases, or the views of a design document with -views", true, NeedConnection, Compact},
+		{"viewcleanup", "Remove index files of views that no longer exist", true, NeedConnection, ViewCleanup},
+		{"security", "Show the security object of the database", false, NeedDatabase, Security},
+		{"grant", "Add users or roles to the admins or members of the database", true, NeedDatabase, Grant},
+		{"revoke", "Remove users or roles from the admins or members of the database", true, NeedDatabase, Revoke},
+		{"users", "List users", false, NeedConnection | Paged, Users},
+		{"useradd", "Create a user: useradd <name> [-roles a,b]", true, NeedConnection, UserAdd},
+		{"passwd", "Change the password of a user", true, NeedConnection, Passwd},
+		{"userdel", "Delete users", true, NeedConnection, UserDel},
+		{"userroles", "Change the roles of a user: userroles <name> +role -role", true, NeedConnection, UserRoles},
+		{"config", "Show the server configuration: config [section [key]], or config set|delete", false, NeedConnection, Config},
+		{"nodes", "Show the cluster membership", false, NeedConnection, Nodes},
+		{"up", "Check the health of the server, or of all nodes with -all", false, NeedConnection, Up},
+		{"node", "Show system and request statistics of a node", false, NeedConnection, Node},
+		{"pager", "Show or set paging of long output (on, off or auto)", false, None, Pager},
 		{"exit", "Exit clippan", false, None, Exit},
 		{"help", "Show help", false, None, Help},
 	}
@@ -81,24 +122,165 @@ func MatchDatabases(c *Clippan, patterns ...string) ([]string, []string, error)
 	return matches, mismatches, nil
 }
 
+// ParseInterspersed parses flags that may appear before, between or after
+// positional arguments, e.g. `query a b -json`. It returns the positional arguments.
+// Everything after `--` is positional
+func ParseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	return ParseInterspersedN(fs, args, 0)
+}
+
+// ParseInterspersedN is ParseInterspersed, but once n (if > 0) positional
+// arguments have been seen the rest is positional as well, so values like
+// -1 can be passed without `--`
+func ParseInterspersedN(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+		if n > 0 && len(positional) >= n {
+			return append(positional, args...), nil
+		}
+	}
+}
+
 func CreateDB(c *Clippan, args []string) error {
-	// Let's assume we also use it immediately
-	if len(args) != 2 {
+	var partitioned, noUse bool
+	var q, n int
+	var from string
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: createdb [flags] <name>...\n")
+		fmt.Fprintf(os.Stderr, "Names may contain {a,b} alternatives, e.g. tenant-{a,b,c}\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&partitioned, "partitioned", false, "Create a partitioned database")
+	fs.IntVar(&q, "q", 0, "Number of shards (server default if 0)")
+	fs.IntVar(&n, "n", 0, "Number of replicas of each shard (server default if 0)")
+	fs.BoolVar(&noUse, "nouse", false, "Don't switch to the created database")
+	fs.StringVar(&from, "from", "", "Copy the security object and design documents from this database")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	var names []string
+	for _, arg := range positional {
+		names = append(names, ExpandBraces(arg)...)
+	}
+	if len(names) == 0 {
+		fs.Usage()
 		return UsageError
 	}
-	db := args[1]
-	if exists, err := c.client.DBExists(context.TODO(), db); err != nil {
+
+	options := kivik.Options{}
+	if partitioned {
+		options["partitioned"] = true
+	}
+	if q > 0 {
+		options["q"] = q
+	}
+	if n > 0 {
+		options["n"] = n
+	}
+
+	var source *kivik.DB
+	if from != "" {
+		if exists, err := c.client.DBExists(context.TODO(), from); err != nil {
+			return err
+		} else if !exists {
+			return fmt.Errorf("%s: %s", from, DatabaseDoesNotExist)
+		}
+		source = c.client.DB(context.TODO(), from)
+	}
+
+	created := 0
+	for _, db := range names {
+		if exists, err := c.client.DBExists(context.TODO(), db); err != nil {
+			return err
+		} else if exists {
+			c.Error("%s: %s", db, DatabaseExists)
+			continue
+		}
+		if err := c.client.CreateDB(context.TODO(), db, options); err != nil {
+			return fmt.Errorf("%s: %s", db, err)
+		}
+		created++
+		if source != nil {
+			if err := copyDatabaseSetup(c, source, c.client.DB(context.TODO(), db)); err != nil {
+				return fmt.Errorf("%s: %s", db, err)
+			}
+		}
+		if len(names) > 1 || noUse {
+			c.Print("Database %s created", db)
+		}
+	}
+	// Let's assume we also use it immediately, if it's just one
+	if created == 1 && len(names) == 1 && !noUse {
+		c.UseDB(names[0])
+	}
+	return nil
+}
+
+// copyDatabaseSetup copies the security object and design documents from source to target
+func copyDatabaseSetup(c *Clippan, source, target *kivik.DB) error {
+	sec, err := source.Security(context.TODO())
+	if err != nil {
+		return err
+	}
+	if err := target.SetSecurity(context.TODO(), sec); err != nil {
 		return err
-	} else if exists {
-		return DatabaseExists
 	}
-	if err := c.client.CreateDB(context.TODO(), db); err != nil {
+	docs, err := GetDesignDocs(source)
+	if err != nil {
 		return err
 	}
-	c.UseDB(db)
+	for _, doc := range docs {
+		id := doc["_id"].(string)
+		if _, ok := doc["_attachments"]; ok {
+			// the stubs can't be stored in another database, get the data
+			doc = nil
+			if err := source.Get(context.TODO(), id, kivik.Options{"attachments": true}).ScanDoc(&doc); err != nil {
+				return fmt.Errorf("%s: %s", id, err)
+			}
+			inlineAttachments(doc)
+		}
+		delete(doc, "_rev")
+		if _, err := target.Put(context.TODO(), id, doc); err != nil {
+			return fmt.Errorf("%s: %s", id, err)
+		}
+	}
+	c.Print("Copied security and %d design document(s) from %s to %s", len(docs), source.Name(), target.Name())
 	return nil
 }
 
+// ExpandBraces expands {a,b} alternatives in s, e.g. db-{a,b} becomes db-a and db-b
+func ExpandBraces(s string) []string {
+	open := strings.Index(s, "{")
+	if open < 0 {
+		return []string{s}
+	}
+	close := strings.Index(s[open:], "}")
+	if close < 0 {
+		return []string{s}
+	}
+	close += open
+	var res []string
+	for _, alternative := range strings.Split(s[open+1:close], ",") {
+		res = append(res, ExpandBraces(s[:open]+alternative+s[close+1:])...)
+	}
+	return res
+}
+
 func DeleteDB(c *Clippan, args []string) error {
 	// Make sure we disconnect from db if we'e currently connected to it
 	force := false
@@ -160,12 +342,20 @@ func Help(c *Clippan, args []string) error {
 
 func Databases(c *Clippan, args []string) error {
 	long := false
+	sizes := false
+	sortBy := ""
 
 	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
 	fs.BoolVar(&long, "l", false, "Long list format")
+	fs.BoolVar(&sizes, "sizes", false, "Show file, active and external sizes (implies -l)")
+	fs.StringVar(&sortBy, "sort", "", "Sort by name, size or docs (largest first, implies -l)")
 	if fs.Parse(args[1:]) == flag.ErrHelp {
 		return nil // help will be printed
 	}
+	if sortBy != "" && sortBy != "name" && sortBy != "size" && sortBy != "docs" {
+		return fmt.Errorf("Can't sort by %s, use name, size or docs", sortBy)
+	}
+	long = long || sizes || sortBy != ""
 
 	patterns := fs.Args()
 	if len(patterns) == 0 {
@@ -180,10 +370,20 @@ func Databases(c *Clippan, args []string) error {
 		if err != nil {
 			return err
 		}
-		c.Print("%-50s %10s %10s", "Name", "#docs", "#deleted")
+		sortStats(stats, sortBy)
+		if sizes {
+			c.Print("%-50s %10s %10s %12s %12s %12s", "Name", "#docs", "#deleted", "File", "Active", "External")
+		} else {
+			c.Print("%-50s %10s %10s", "Name", "#docs", "#deleted")
+		}
 		for _, s := range stats {
 			// Possibly truncate, ellipsize name
-			c.Print("%-50s %10d %10d", s.Name, s.DocCount, s.DeletedCount)
+			if sizes {
+				c.Print("%-50s %10d %10d %12s %12s %12s", s.Name, s.DocCount, s.DeletedCount,
+					FormatSize(s.DiskSize), FormatSize(s.ActiveSize), FormatSize(s.ExternalSize))
+			} else {
+				c.Print("%-50s %10d %10d", s.Name, s.DocCount, s.DeletedCount)
+			}
 		}
 
 	} else {
@@ -197,6 +397,20 @@ func Databases(c *Clippan, args []string) error {
 	return nil
 }
 
+// sortStats sorts database stats by name, (file) size or doc count. Sizes
+// and counts are sorted largest first
+func sortStats(stats []*kivik.DBStats, sortBy string) {
+	sort.SliceStable(stats, func(i, j int) bool {
+		switch sortBy {
+		case "size":
+			return stats[i].DiskSize > stats[j].DiskSize
+		case "docs":
+			return stats[i].DocCount > stats[j].DocCount
+		}
+		return stats[i].Name < stats[j].Name
+	})
+}
+
 func UseDB(c *Clippan, args []string) error {
 	if len(args) != 2 {
 		return UsageError
@@ -206,6 +420,93 @@ func UseDB(c *Clippan, args []string) error {
 	return nil
 }
 
+// shardsDatabase returns the database given as the only positional argument,
+// or the current database
+func shardsDatabase(c *Clippan, positional []string) (string, error) {
+	if len(positional) == 1 {
+		return positional[0], nil
+	} else if len(positional) > 1 {
+		return "", UsageError
+	} else if c.database == nil {
+		return "", NoDatabaseError
+	}
+	return c.database.Name(), nil
+}
+
+// Shards shows the shard ranges of a database and the nodes they live on,
+// or the shard a document id belongs to
+func Shards(c *Clippan, args []string) error {
+	var id string
+	var useJson bool
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: shards [flags] [database]\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&id, "id", "", "Show the shard this document id belongs to")
+	fs.BoolVar(&useJson, "json", false, "Output json")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	db, err := shardsDatabase(c, positional)
+	if err != nil {
+		return err
+	}
+	path := "/" + url.PathEscape(db) + "/_shards"
+
+	if id != "" {
+		var shard struct {
+			Range string   `json:"range"`
+			Nodes []string `json:"nodes"`
+		}
+		if err := c.Get(path+"/"+url.PathEscape(id), &shard); err != nil {
+			return err
+		}
+		if useJson {
+			c.JSON(MustMarshal(shard))
+		} else {
+			c.Print("%s is in range %s on %s", id, shard.Range, strings.Join(shard.Nodes, ", "))
+		}
+		return nil
+	}
+
+	var shards struct {
+		Shards map[string][]string `json:"shards"`
+	}
+	if err := c.Get(path, &shards); err != nil {
+		return err
+	}
+	if useJson {
+		c.JSON(MustMarshal(shards.Shards))
+		return nil
+	}
+	ranges := make([]string, 0, len(shards.Shards))
+	for r := range shards.Shards {
+		ranges = append(ranges, r)
+	}
+	sort.Strings(ranges)
+	c.Print("%-20s %s", "Range", "Nodes")
+	for _, r := range ranges {
+		c.Print("%-20s %s", r, strings.Join(shards.Shards[r], ", "))
+	}
+	return nil
+}
+
+// SyncShards forces synchronization of the shard replicas of a database
+func SyncShards(c *Clippan, args []string) error {
+	db, err := shardsDatabase(c, args[1:])
+	if err != nil {
+		return err
+	}
+	if err := c.Request(http.MethodPost, "/"+url.PathEscape(db)+"/_sync_shards", map[string]interface{}{}, nil); err != nil {
+		return err
+	}
+	c.Print("Synchronization of the shards of %s started", db)
+	return nil
+}
+
 // Get returns a single document
 func Get(c *Clippan, args []string) error {
 	if c.database == nil {
@@ -234,49 +535,219 @@ func Get(c *Clippan, args []string) error {
 	return nil
 }
 
-// AllDocs simply returns what _all_docs returns, Will eventually
-// support pagination and simple start/end filtering
+// AllDocs lists what _all_docs returns, optionally limited to a prefix or
+// a start/end range. Limited listings can be continued using `next` and
+// `prev` or, with -page, interactively
 func AllDocs(c *Clippan, args []string) error {
 	if c.database == nil {
 		return NoDatabaseError
 	}
-	var options kivik.Options
+	var page, descending, includeDocs, conflicts, design bool
+	var limit, skip int
+	var start, end, keys, partition string
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: all [flags] [prefix]\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&page, "page", false, "Interactively fetch and show a page at a time")
+	fs.IntVar(&limit, "limit", 0, "Max amount of docs to show (per page)")
+	fs.IntVar(&skip, "skip", 0, "Amount of docs to skip")
+	fs.StringVar(&start, "start", "", "Start listing at this id")
+	fs.StringVar(&end, "end", "", "End listing at this id")
+	fs.BoolVar(&descending, "descending", false, "List in descending order")
+	fs.BoolVar(&includeDocs, "include-docs", false, "Show the documents")
+	fs.StringVar(&keys, "keys", "", "Comma separated list of ids to show")
+	fs.BoolVar(&conflicts, "conflicts", false, "Show conflicts (implies -include-docs)")
+	fs.BoolVar(&design, "design", false, "Only list design documents")
+	fs.StringVar(&partition, "partition", "", "Only list this partition (partitioned databases)")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(positional) > 1 {
+		fs.Usage()
+		return UsageError
+	}
 
-	pattern := ""
-	if len(args) > 1 {
-		pattern = args[1]
+	prefix := ""
+	if len(positional) == 1 {
+		prefix = positional[0]
 	}
-	if pattern != "" {
-		options = kivik.Options{
-			"start_key": pattern,
-			"end_key":   pattern + "\ufff0",
+	if design {
+		prefix = "_design/" + prefix
+	}
+	if prefix != "" {
+		if start != "" || end != "" {
+			return errors.New("a prefix can't be combined with -start or -end")
+		}
+		start, end = prefix, prefix+"\ufff0"
+		if descending {
+			start, end = end, start
 		}
 	}
 
-	rows, err := c.database.AllDocs(context.TODO(), options)
+	options := kivik.Options{}
+	if start != "" {
+		options["start_key"] = start
+	}
+	if end != "" {
+		options["end_key"] = end
+	}
+	if descending {
+		options["descending"] = true
+	}
+	if skip > 0 {
+		options["skip"] = skip
+	}
+	if keys != "" {
+		options["keys"] = strings.Split(keys, ",")
+	}
+	if conflicts {
+		options["conflicts"] = true
+		includeDocs = true
+	}
+	if includeDocs {
+		options["include_docs"] = true
+	}
+	if partition != "" {
+		options[couchdb.OptionPartition] = partition
+	}
+	if page && limit == 0 {
+		limit = pageSize()
+	}
+	if limit > 0 {
+		options["limit"] = limit
+	}
+
+	c.listing = &Listing{limit: limit}
+	if err := c.listing.Show(c, options); err != nil {
+		return err
+	}
+	for page && c.listing.HasMore() && c.NextPage() {
+		if err := c.listing.Next(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Listing remembers the pages of the last `all` command so it can be
+// continued using `next` and `prev`
+type Listing struct {
+	pages  []kivik.Options // the options used for each page shown so far
+	limit  int
+	count  int    // number of docs on the current page
+	lastID string // last id on the current page
+}
+
+// Show fetches and shows the page for options, making it the current page
+func (l *Listing) Show(c *Clippan, options kivik.Options) error {
+	count, lastID, err := allDocsPage(c, options)
 	if err != nil {
 		return err
 	}
+	l.pages = append(l.pages, options)
+	l.count, l.lastID = count, lastID
+	return nil
+}
+
+// HasMore returns if there may be more docs after the current page
+func (l *Listing) HasMore() bool {
+	return l.limit > 0 && l.count == l.limit
+}
+
+// Next shows the page following the current page
+func (l *Listing) Next(c *Clippan) error {
+	if !l.HasMore() {
+		c.Print("No more documents")
+		return nil
+	}
+	options := kivik.Options{}
+	for k, v := range l.pages[len(l.pages)-1] {
+		options[k] = v
+	}
+	if _, ok := options["keys"]; ok {
+		// there's no key order to continue from
+		skip, _ := options["skip"].(int)
+		options["skip"] = skip + l.limit
+	} else {
+		// continue after the last id shown
+		options["start_key"] = l.lastID
+		options["skip"] = 1
+	}
+	return l.Show(c, options)
+}
+
+// Prev shows the page before the current page
+func (l *Listing) Prev(c *Clippan) error {
+	if len(l.pages) < 2 {
+		c.Print("Already at the first page")
+		return nil
+	}
+	options := l.pages[len(l.pages)-2]
+	l.pages = l.pages[:len(l.pages)-2]
+	return l.Show(c, options)
+}
+
+// allDocsPage shows a single batch of _all_docs rows, returning the number
+// of rows and the last id shown
+func allDocsPage(c *Clippan, options kivik.Options) (int, string, error) {
+	rows, err := c.database.AllDocs(context.TODO(), options)
+	if err != nil {
+		return 0, "", err
+	}
 	defer rows.Close()
+
+	includeDocs, _ := options["include_docs"].(bool)
+
+	count := 0
+	lastID := ""
 	for rows.Next() {
 		var key, value interface{}
 		if err := rows.ScanKey(&key); err != nil {
-			return err
+			return 0, "", err
 		}
 		if err := rows.ScanValue(&value); err != nil {
-			return err
+			return 0, "", err
 		}
 		// Abuse json.Marshal to get a representation of value
 		data, err := json.Marshal(value)
 		if err != nil {
-			return err
+			return 0, "", err
 		}
 		c.Print("%s %v %+v", rows.ID(), key, string(data))
+		if includeDocs {
+			var doc interface{}
+			if err := rows.ScanDoc(&doc); err != nil {
+				return 0, "", err
+			}
+			c.JSON(MustMarshal(doc))
+		}
+		count++
+		lastID = rows.ID()
 	}
-	if rows.Err() != nil {
-		return err
+	if err := rows.Err(); err != nil {
+		return 0, "", err
 	}
-	return nil
+	return count, lastID, nil
+}
+
+// Next continues the last `all` listing
+func Next(c *Clippan, args []string) error {
+	if c.listing == nil {
+		return NoListingError
+	}
+	return c.listing.Next(c)
+}
+
+// Prev shows the previous page of the last `all` listing
+func Prev(c *Clippan, args []string) error {
+	if c.listing == nil {
+		return NoListingError
+	}
+	return c.listing.Prev(c)
 }
 
 // GetDocRaw gets a document as raw bytes. It returns DocumentNotFoundError
@@ -313,6 +784,9 @@ func EditPut(c *Clippan, args []string, onlyEdit bool) error {
 		return UsageError
 	}
 	id := fs.Arg(0)
+	if err := ValidatePartitionedID(c, id); err != nil {
+		return err
+	}
 
 	data, _, err := GetDocRaw(c, id)
 	// There's no reason not to make it pretty. Fauxton does it as well
@@ -363,6 +837,7 @@ func EditPut(c *Clippan, args []string, onlyEdit bool) error {
 				return err
 			}
 			rev = doc["_rev"].(string)
+			printConflict(c, newerData, data)
 			in := c.Prompt.Input("Conflict with rev " + rev + ". (A)bort, [(F)orce] or (E)dit with diff?> ")
 			in = strings.ToLower(in)
 			if in == "a" {
@@ -407,6 +882,18 @@ type QueryResult struct {
 	ID    string      `json:"id"`
 	Key   interface{} `json:"key"`
 	Value interface{} `json:"value"`
+	Doc   interface{} `json:"doc,omitempty"`
+}
+
+// ParseJSONArg parses a command line argument as JSON. Anything that
+// isn't valid JSON is taken to be a plain string, so `-key foo` works
+// as well as `-key '"foo"'`
+func ParseJSONArg(arg string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(arg), &v); err != nil {
+		return arg
+	}
+	return v
 }
 
 // Query might be aliased / shortcut to Map(view), Reduce?
@@ -415,70 +902,183 @@ func Query(c *Clippan, args []string) error {
 	 * Steps:
 	 * - query a simple view, list all results
 	 */
-	var reduce, useJson bool
+	var reduce, useJson, page, descending, inclusiveEnd, includeDocs, group, sorted bool
 	var level int
-	var limit int
+	var limit, skip int
+	var key, keys, startKey, endKey, stale, update, partition string
 
 	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
 
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: query [flags] design-doc view.\n")
 		fmt.Fprintf(os.Stderr, "e.g. to query _design/employee _view/by-age, run `query employee by-age`\n")
+		fmt.Fprintf(os.Stderr, "Keys are parsed as JSON, anything that isn't valid JSON is used as a string\n")
 		fs.PrintDefaults()
 	}
 	fs.BoolVar(&reduce, "reduce", false, "Reduce query")
-	fs.IntVar(&level, "level", 0, "Reduce group level")
+	fs.IntVar(&level, "level", 0, "Reduce group level (implies -reduce)")
+	fs.BoolVar(&group, "group", false, "Group reduce results by the full key (implies -reduce)")
 	fs.BoolVar(&useJson, "json", false, "Output json")
-	fs.IntVar(&limit, "limit", 50, "Max amount of entries to show")
-	if fs.Parse(args[1:]) != nil {
+	fs.IntVar(&limit, "limit", 50, "Max amount of entries to show (per page, with -page)")
+	fs.IntVar(&skip, "skip", 0, "Amount of entries to skip")
+	fs.BoolVar(&page, "page", false, "Interactively fetch and show a page at a time")
+	fs.StringVar(&key, "key", "", "Only return entries matching this key")
+	fs.StringVar(&keys, "keys", "", "Only return entries matching these keys (a JSON array)")
+	fs.StringVar(&startKey, "startkey", "", "Start at this key")
+	fs.StringVar(&endKey, "endkey", "", "End at this key")
+	fs.BoolVar(&descending, "descending", false, "Return entries in descending order")
+	fs.BoolVar(&inclusiveEnd, "inclusive-end", true, "Include entries matching endkey")
+	fs.BoolVar(&includeDocs, "include-docs", false, "Include (and show) the documents")
+	fs.StringVar(&stale, "stale", "", "Allow stale results: ok or update_after")
+	fs.StringVar(&update, "update", "", "Update the view before returning results: true, false or lazy")
+	fs.BoolVar(&sorted, "sorted", true, "Sort the results")
+	fs.StringVar(&partition, "partition", "", "Only query this partition (partitioned databases)")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
 		return nil // help will have been printed
 	}
-	if fs.NArg() != 2 {
+	if len(positional) != 2 {
 		fs.Usage()
 		// c.Error("Please specify designdoc and view")
 		return nil
 	}
-	ddoc := fs.Arg(0)
-	view := fs.Arg(1)
-	c.Print("Querying %s / %s", ddoc, view)
+	ddoc := positional[0]
+	view := positional[1]
+
+	if group || level > 0 {
+		reduce = true
+	}
+	if group && level > 0 {
+		return errors.New("-group and -level can't be combined")
+	}
+	if reduce && includeDocs {
+		return errors.New("-include-docs can't be used on reduce queries")
+	}
+	if key != "" && keys != "" {
+		return errors.New("-key and -keys can't be combined")
+	}
+	if page && (key != "" || keys != "") {
+		// paging moves startkey, which doesn't combine with fixed keys
+		return errors.New("-page can't be combined with -key or -keys")
+	}
 
 	options := kivik.Options{
-		// "startkey":     "",
-		// "endkey":       endKey,
-		// "include_docs": true,
-		"skip":   0,
+		"skip":   skip,
 		"limit":  limit,
 		"reduce": false,
 	}
 
 	if reduce {
 		options["reduce"] = true
-		options["group_level"] = level
+		if group {
+			options["group"] = true
+		} else {
+			options["group_level"] = level
+		}
+	}
+	if key != "" {
+		options["key"] = ParseJSONArg(key)
+	}
+	if keys != "" {
+		var parsed []interface{}
+		if err := json.Unmarshal([]byte(keys), &parsed); err != nil {
+			return fmt.Errorf("-keys should be a JSON array: %s", err)
+		}
+		options["keys"] = parsed
+	}
+	if startKey != "" {
+		options["startkey"] = ParseJSONArg(startKey)
+	}
+	if endKey != "" {
+		options["endkey"] = ParseJSONArg(endKey)
+	}
+	if descending {
+		options["descending"] = true
+	}
+	if !inclusiveEnd {
+		options["inclusive_end"] = false
+	}
+	if includeDocs {
+		options["include_docs"] = true
+	}
+	if stale != "" {
+		options["stale"] = stale
+	}
+	if update != "" {
+		options["update"] = update
 	}
+	if !sorted {
+		options["sorted"] = false
+	}
+	if partition != "" {
+		options[couchdb.OptionPartition] = partition
+	}
+
+	if !useJson {
+		// json output should be clean, e.g. when redirected to a file
+		c.Print("Querying %s / %s", ddoc, view)
+	}
+
+	for {
+		result, err := queryPage(c, ddoc, view, options)
+		if err != nil {
+			return err
+		}
+		printQueryResults(c, result, useJson)
+
+		if !page || len(result) < limit || !c.NextPage() {
+			return nil
+		}
+		_, hasKeys := options["keys"]
+		if reduce || hasKeys {
+			// reduced rows have no doc id, keys no order to continue from
+			options["skip"] = options["skip"].(int) + limit
+		} else {
+			last := result[len(result)-1]
+			options["startkey"] = last.Key
+			options["startkey_docid"] = last.ID
+			options["skip"] = 1
+		}
+	}
+}
+
+// queryPage fetches a single batch of view results
+func queryPage(c *Clippan, ddoc, view string, options kivik.Options) ([]*QueryResult, error) {
 	rows, err := c.database.Query(context.TODO(),
 		"_design/"+ddoc, "_view/"+view, options,
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer rows.Close()
 
+	includeDocs, _ := options["include_docs"].(bool)
+
 	var result []*QueryResult
 
 	for rows.Next() {
 		var key, value interface{}
-		// var doc struct{ _id string }
 		if err = rows.ScanKey(&key); err != nil {
-			return err
+			return nil, err
 		}
 		if err = rows.ScanValue(&value); err != nil {
-			return err
+			return nil, err
+		}
+		r := &QueryResult{ID: rows.ID(), Key: key, Value: value}
+		if includeDocs {
+			if err := rows.ScanDoc(&r.Doc); err != nil {
+				return nil, err
+			}
 		}
-		// if err := rows.ScanDoc(&doc); err != nil {
-		// 	return err
-		// }
-		result = append(result, &QueryResult{ID: rows.ID(), Key: key, Value: value})
+		result = append(result, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
+	return result, nil
+}
+
+func printQueryResults(c *Clippan, result []*QueryResult, useJson bool) {
 	if useJson {
 		c.JSON(MustMarshal(result))
 	} else {
@@ -487,12 +1087,13 @@ func Query(c *Clippan, args []string) error {
 		for _, r := range result {
 
 			c.Print("%-30v %20v %20s", r.Key, r.Value, r.ID)
+			if r.Doc != nil {
+				c.JSON(MustMarshal(r.Doc))
+			}
 			count += 1
 		}
 		c.Print("\n%d results shown", count)
 	}
-
-	return nil
 }
 
 func MustMarshal(v interface{}) []byte {
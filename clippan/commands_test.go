@@ -2,6 +2,8 @@ package clippan
 
 import (
 	"context"
+	"flag"
+	"io/ioutil"
 	"strings"
 	"testing"
 
@@ -40,6 +42,59 @@ func TestCommands(t *testing.T) {
 
 		assert.Len(printer.Errors, 1)
 	}))
+	t.Run("Test `createdb` options", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, true, printer, NewMockEditor(), NewMockPrompt())
+		testDBs := []string{"testing-createdb-a", "testing-createdb-b"}
+		for _, db := range testDBs {
+			if exists, _ := cdb.Client().DBExists(context.TODO(), db); exists {
+				assert.NoError(cdb.Client().DestroyDB(context.TODO(), db))
+			}
+			defer cdb.Client().DestroyDB(context.TODO(), db) // nolint: errcheck
+		}
+		_, err := cdb.DB().Put(context.TODO(), "_design/test", map[string]interface{}{
+			"language": "javascript",
+			"_attachments": map[string]interface{}{
+				"index.html": map[string]interface{}{"content_type": "text/html", "data": "PGh0bWw+"},
+			},
+		})
+		assert.NoError(err)
+
+		c.Executer("createdb -q 1 -from " + cdb.DB().Name() + " testing-createdb-{a,b}")
+		assert.Len(printer.Errors, 0)
+		assert.Nil(c.database)
+		for _, db := range testDBs {
+			var doc map[string]interface{}
+			found, err := helpers.GetOr404(cdb.Client().DB(context.TODO(), db), "_design/test", &doc)
+			assert.NoError(err)
+			assert.True(found)
+			assert.Contains(doc["_attachments"], "index.html")
+		}
+
+		c.Executer("createdb testing-createdb-a")
+		assert.Len(printer.Errors, 1)
+	}))
+	t.Run("Test `shards`", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("shards")
+		assert.Len(printer.Errors, 1)
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("shards")
+		c.Executer("shards -id doc1")
+		assert.Len(printer.Errors, 1)
+		assert.Equal("Range                Nodes\n", printer.Prints[0])
+		assert.Contains(printer.Prints[len(printer.Prints)-1], "doc1 is in range")
+
+		c.Executer("sync_shards")
+		assert.Len(printer.Errors, 2)
+	}))
 }
 
 func TestEditPut(t *testing.T) {
@@ -453,4 +508,210 @@ func TestQuery(t *testing.T) {
 		assert.EqualValues(123, res[1].Value.(float64))
 		assert.EqualValues("John_Doe", res[1].Key.([]interface{})[0].(string))
 	}))
+	t.Run("Test query options", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+
+		setUp(cdb, t)
+		c := NewTestClippan(cdb,
+			true,
+			printer,
+			NewMockEditor(),
+			NewMockPrompt().SetMockData("a"),
+		)
+
+		// Activate the testing database
+		c.Executer("use " + cdb.DB().Name())
+
+		var res []*QueryResult
+		c.Executer(`query -json -key '["John_Doe"]' testview v1`)
+		MustUnmarshal(printer.JSONS[0], &res)
+		assert.Len(res, 1)
+		assert.Equal("entry1", res[0].ID)
+
+		res = nil
+		c.Executer("query -json -descending testview v1")
+		MustUnmarshal(printer.JSONS[1], &res)
+		assert.Len(res, 2)
+		assert.Equal("entry1", res[0].ID)
+
+		res = nil
+		c.Executer(`query -json -startkey '["Jane_Doe"]' -endkey '["John_Doe"]' -inclusive-end=false testview v1`)
+		MustUnmarshal(printer.JSONS[2], &res)
+		assert.Len(res, 1)
+		assert.Equal("entry2", res[0].ID)
+
+		res = nil
+		c.Executer("query -json -include-docs testview v1")
+		MustUnmarshal(printer.JSONS[3], &res)
+		assert.Len(res, 2)
+		assert.EqualValues("Jane", res[0].Doc.(map[string]interface{})["firstname"])
+
+		res = nil
+		c.Executer("query -json -group testview v1")
+		MustUnmarshal(printer.JSONS[4], &res)
+		assert.Len(res, 2)
+		assert.EqualValues(22, res[0].Value.(float64))
+		assert.Len(printer.Errors, 0)
+
+		c.Executer("query -json -reduce -include-docs testview v1")
+		assert.Len(printer.Errors, 1)
+
+		c.Executer("query -page -key 22 testview v1")
+		c.Executer(`query -page -keys [22] testview v1`)
+		assert.Len(printer.Errors, 3)
+	}))
+}
+
+func TestParseJSONArg(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("foo", ParseJSONArg("foo"))
+	assert.Equal("foo", ParseJSONArg(`"foo"`))
+	assert.Equal(float64(42), ParseJSONArg("42"))
+	assert.Equal([]interface{}{"a", float64(1)}, ParseJSONArg(`["a", 1]`))
+}
+
+func TestExpandBraces(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{"foo"}, ExpandBraces("foo"))
+	assert.Equal([]string{"db-a", "db-b"}, ExpandBraces("db-{a,b}"))
+	assert.Equal([]string{"a1", "a2", "b1", "b2"}, ExpandBraces("{a,b}{1,2}"))
+	assert.Equal([]string{"db-{a"}, ExpandBraces("db-{a"))
+}
+
+func TestParseInterspersed(t *testing.T) {
+	assert := assert.New(t)
+	newFlags := func(b *bool) *flag.FlagSet {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		fs.BoolVar(b, "json", false, "")
+		return fs
+	}
+
+	var json bool
+	positional, err := ParseInterspersed(newFlags(&json), []string{"a", "-json", "b"})
+	assert.NoError(err)
+	assert.Equal([]string{"a", "b"}, positional)
+	assert.True(json)
+
+	json = false
+	positional, err = ParseInterspersed(newFlags(&json), []string{"a", "--", "-json", "b"})
+	assert.NoError(err)
+	assert.Equal([]string{"a", "-json", "b"}, positional)
+	assert.False(json)
+
+	positional, err = ParseInterspersedN(newFlags(&json), []string{"-json", "s", "k", "-1", "-json"}, 2)
+	assert.NoError(err)
+	assert.Equal([]string{"s", "k", "-1", "-json"}, positional)
+	assert.True(json)
+
+	_, err = ParseInterspersed(newFlags(&json), []string{"s", "k", "-1"})
+	assert.Error(err)
+}
+
+func TestAllDocs(t *testing.T) {
+	DB := helpers.DBSession("test-all-docs")
+
+	setUp := func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		for _, id := range []string{"a1", "a2", "a3", "b1", "b2", "_design/x"} {
+			_, err := cdb.DB().Put(context.TODO(), id, map[string]interface{}{"v": id})
+			assert.NoError(err)
+		}
+	}
+	// ids returns the document ids from the printed rows
+	ids := func(prints []string) []string {
+		res := []string{}
+		for _, p := range prints {
+			res = append(res, strings.SplitN(p, " ", 2)[0])
+		}
+		return res
+	}
+
+	t.Run("Test prefix", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		setUp(cdb, t)
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("all a")
+		assert.Len(printer.Errors, 0)
+		assert.Equal([]string{"a1", "a2", "a3"}, ids(printer.Prints))
+
+		// flags may follow the prefix
+		printer.Prints = nil
+		c.Executer("all a -limit 2")
+		assert.Len(printer.Errors, 0)
+		assert.Equal([]string{"a1", "a2"}, ids(printer.Prints))
+
+		c.Executer("all a b")
+		assert.Len(printer.Errors, 1)
+	}))
+	t.Run("Test options", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		setUp(cdb, t)
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("all -design")
+		assert.Equal([]string{"_design/x"}, ids(printer.Prints))
+
+		printer.Prints = nil
+		c.Executer("all -descending -skip 1 -limit 2 a")
+		assert.Equal([]string{"a2", "a1"}, ids(printer.Prints))
+
+		printer.Prints = nil
+		c.Executer("all -start a3 -end b1")
+		assert.Equal([]string{"a3", "b1"}, ids(printer.Prints))
+
+		printer.Prints = nil
+		c.Executer("all -keys b2,a1 -include-docs")
+		assert.Equal([]string{"b2", "a1"}, ids(printer.Prints))
+		assert.Len(printer.JSONS, 2)
+		assert.Len(printer.Errors, 0)
+	}))
+	t.Run("Test next and prev", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		setUp(cdb, t)
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("next")
+		assert.Len(printer.Errors, 1)
+
+		printer.Prints = nil
+		c.Executer("all -limit 2 -start a")
+		assert.Equal([]string{"a1", "a2"}, ids(printer.Prints))
+
+		printer.Prints = nil
+		c.Executer("next")
+		assert.Equal([]string{"a3", "b1"}, ids(printer.Prints))
+
+		printer.Prints = nil
+		c.Executer("prev")
+		assert.Equal([]string{"a1", "a2"}, ids(printer.Prints))
+
+		printer.Prints = nil
+		c.Executer("prev")
+		assert.Equal([]string{"Already at the first page\n"}, printer.Prints)
+	}))
+	t.Run("Test interactive paging", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		setUp(cdb, t)
+		prompt := NewMockPrompt()
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), prompt)
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("all -page -limit 2 -start a")
+		assert.Len(printer.Errors, 0)
+		assert.Equal([]string{"a1", "a2", "a3", "b1", "b2"}, ids(printer.Prints))
+		// asked after the first two (full) pages
+		assert.Len(prompt.Inputs, 2)
+	}))
 }
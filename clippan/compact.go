@@ -0,0 +1,180 @@
+package clippan
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// targetDatabases returns the databases matching patterns or, if there are
+// none, the current database
+func targetDatabases(c *Clippan, patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		if c.database == nil {
+			return nil, NoDatabaseError
+		}
+		return []string{c.database.Name()}, nil
+	}
+	matches, mismatches, err := MatchDatabases(c, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	for _, mismatch := range mismatches {
+		c.Error("No matches for pattern %s", mismatch)
+	}
+	return matches, nil
+}
+
+// compactionSize returns the file and active size of a database or, if
+// ddoc is set, of the index of a design document
+func compactionSize(c *Clippan, db, ddoc string) (int64, int64, bool, error) {
+	if ddoc != "" {
+		info, _, err := GetViewInfo(c, db, ddoc)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return info.Sizes.File, info.Sizes.Active, info.CompactRunning, nil
+	}
+	stats, err := c.client.DB(context.TODO(), db).Stats(context.TODO())
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return stats.DiskSize, stats.ActiveSize, stats.CompactRunning, nil
+}
+
+// compactionProgress returns the average progress of the compaction tasks of
+// a database (or design document) over all shards. It returns false if there are none
+func compactionProgress(tasks []*ActiveTask, db, ddoc string) (int, bool) {
+	taskType := "database_compaction"
+	if ddoc != "" {
+		taskType = "view_compaction"
+	}
+	progress, count := 0, 0
+	for _, t := range tasks {
+		if t.Type != taskType || t.DatabaseName() != db || t.DesignDocument != ddoc {
+			continue
+		}
+		progress += t.Progress
+		count++
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return progress / count, true
+}
+
+// waitForCompaction shows the progress of a compaction until it's done. It
+// returns false if interrupted
+func waitForCompaction(c *Clippan, db, ddoc string, interval time.Duration) (bool, error) {
+	name := db
+	if ddoc != "" {
+		name += "/" + ddoc
+	}
+	shown := false
+	done, err := watch(interval, func() (bool, error) {
+		tasks, err := ActiveTasks(c)
+		if err != nil {
+			return false, err
+		}
+		progress, compacting := compactionProgress(tasks, db, ddoc)
+		if !compacting {
+			_, _, running, err := compactionSize(c, db, ddoc)
+			if err != nil || !running {
+				return true, err
+			}
+		}
+		fmt.Fprintf(os.Stderr, "\r%s %s", name, ProgressBar(progress, 100, 40))
+		shown = true
+		return false, nil
+	})
+	if shown {
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+	return done, err
+}
+
+// Compact compacts databases or the views of a design document
+func Compact(c *Clippan, args []string) error {
+	var ddoc string
+	var wait bool
+	var interval time.Duration
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: compact [flags] [database pattern...]\n")
+		fmt.Fprintf(os.Stderr, "Compacts the current database if no patterns are given\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&ddoc, "views", "", "Compact the views of this design document in stead of the database")
+	fs.BoolVar(&wait, "wait", false, "Wait for the compaction to finish and show the size gained")
+	fs.DurationVar(&interval, "interval", time.Second, "How often to check the progress with -wait")
+	patterns, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if ddoc != "" {
+		ddoc = DesignDocID(ddoc)
+	}
+
+	dbs, err := targetDatabases(c, patterns)
+	if err != nil {
+		return err
+	}
+	for _, db := range dbs {
+		name := db
+		if ddoc != "" {
+			name += "/" + ddoc
+		}
+		before, active, _, err := compactionSize(c, db, ddoc)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+
+		database := c.client.DB(context.TODO(), db)
+		if ddoc != "" {
+			err = database.CompactView(context.TODO(), strings.TrimPrefix(ddoc, "_design/"))
+		} else {
+			err = database.Compact(context.TODO())
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		if !wait {
+			c.Print("Compaction of %s started (file size %s, active %s)", name, FormatSize(before), FormatSize(active))
+			continue
+		}
+
+		done, err := waitForCompaction(c, db, ddoc, interval)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		if !done {
+			c.Print("Stopped waiting, the compaction of %s continues", name)
+			return nil
+		}
+		after, _, _, err := compactionSize(c, db, ddoc)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		c.Print("Compacted %s: %s -> %s", name, FormatSize(before), FormatSize(after))
+	}
+	return nil
+}
+
+// ViewCleanup removes the index files of views that no longer exist
+func ViewCleanup(c *Clippan, args []string) error {
+	dbs, err := targetDatabases(c, args[1:])
+	if err != nil {
+		return err
+	}
+	for _, db := range dbs {
+		if err := c.client.DB(context.TODO(), db).ViewCleanup(context.TODO()); err != nil {
+			return fmt.Errorf("%s: %s", db, err)
+		}
+		c.Print("View cleanup of %s started", db)
+	}
+	return nil
+}
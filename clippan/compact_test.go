@@ -0,0 +1,66 @@
+package clippan
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactionProgress(t *testing.T) {
+	assert := assert.New(t)
+	tasks := []*ActiveTask{
+		{Type: "database_compaction", Database: "shards/00000000-7fffffff/db.1600000000", Progress: 20},
+		{Type: "database_compaction", Database: "shards/80000000-ffffffff/db.1600000000", Progress: 60},
+		{Type: "view_compaction", Database: "shards/80000000-ffffffff/db.1600000000", DesignDocument: "_design/a", Progress: 10},
+	}
+	progress, found := compactionProgress(tasks, "db", "")
+	assert.True(found)
+	assert.Equal(40, progress)
+
+	progress, found = compactionProgress(tasks, "db", "_design/a")
+	assert.True(found)
+	assert.Equal(10, progress)
+
+	_, found = compactionProgress(tasks, "other", "")
+	assert.False(found)
+}
+
+func TestCompact(t *testing.T) {
+	DB := helpers.DBSession("test-compact")
+
+	t.Run("Test compact in ro mode", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("compact")
+		assert.Len(t, printer.Errors, 1)
+	}))
+	t.Run("Test compact and wait", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, true, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("compact -wait " + cdb.DB().Name())
+		assert.Len(printer.Errors, 0)
+		assert.Contains(strings.Join(printer.Prints, "\n"), "Compacted "+cdb.DB().Name())
+	}))
+	t.Run("Test compact views", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		_, err := cdb.DB().Put(context.TODO(), "_design/test", testDesignDoc)
+		assert.NoError(err)
+		c := NewTestClippan(cdb, true, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("compact -views test")
+		c.Executer("viewcleanup")
+		assert.Len(printer.Errors, 0)
+		output := strings.Join(printer.Prints, "\n")
+		assert.Contains(output, "Compaction of "+cdb.DB().Name()+"/_design/test started")
+		assert.Contains(output, "View cleanup of "+cdb.DB().Name()+" started")
+	}))
+}
@@ -0,0 +1,216 @@
+package clippan
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+const localNode = "_local"
+
+// Config shows or changes the server configuration
+func Config(c *Clippan, args []string) error {
+	if len(args) > 1 {
+		switch args[1] {
+		case "set":
+			return SetConfig(c, args[1:])
+		case "delete":
+			return DeleteConfig(c, args[1:])
+		}
+	}
+	return ShowConfig(c, args)
+}
+
+func configFlags(name, usage string, node *string, force *bool) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n", usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(node, "node", localNode, "Node to read or change the configuration of")
+	if force != nil {
+		fs.BoolVar(force, "f", false, "Don't ask for confirmation")
+	}
+	return fs
+}
+
+func sortedSectionKeys(section kivik.ConfigSection) []string {
+	keys := make([]string, 0, len(section))
+	for k := range section {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ShowConfig shows the configuration of a node, or a section or key of it
+func ShowConfig(c *Clippan, args []string) error {
+	var node string
+	var useJson bool
+
+	fs := configFlags(args[0], "config [flags] [section [key]], config set|delete ... (see config set -h)", &node, nil)
+	fs.BoolVar(&useJson, "json", false, "Output json")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+
+	var config kivik.Config
+	switch len(positional) {
+	case 0:
+		if config, err = c.client.Config(context.TODO(), node); err != nil {
+			return err
+		}
+	case 1:
+		section, err := c.client.ConfigSection(context.TODO(), node, positional[0])
+		if err != nil {
+			return err
+		}
+		config = kivik.Config{positional[0]: section}
+	case 2:
+		value, err := c.client.ConfigValue(context.TODO(), node, positional[0], positional[1])
+		if err != nil {
+			return err
+		}
+		if useJson {
+			c.JSON(MustMarshal(value))
+		} else {
+			c.Print("%s", value)
+		}
+		return nil
+	default:
+		fs.Usage()
+		return UsageError
+	}
+
+	if useJson {
+		c.JSON(MustMarshal(config))
+		return nil
+	}
+	sections := make([]string, 0, len(config))
+	for name := range config {
+		sections = append(sections, name)
+	}
+	sort.Strings(sections)
+	for i, name := range sections {
+		if i > 0 {
+			c.Print("")
+		}
+		c.Print("[%s]", name)
+		for _, key := range sortedSectionKeys(config[name]) {
+			c.Print("%s = %s", key, config[name][key])
+		}
+	}
+	return nil
+}
+
+// currentConfigValue returns the current value of a key, and false if it's not set
+func currentConfigValue(c *Clippan, node, section, key string) (string, bool, error) {
+	value, err := c.client.ConfigValue(context.TODO(), node, section, key)
+	if StatusCode(err) == http.StatusNotFound {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// confirmConfigChange shows the change of a configuration key and asks for confirmation
+func confirmConfigChange(c *Clippan, force bool, node, section, key, old string, exists bool, new *string) bool {
+	c.Print("[%s] on %s", section, node)
+	if exists {
+		c.Print("- %s = %s", key, old)
+	}
+	if new != nil {
+		c.Print("+ %s = %s", key, *new)
+	}
+	if force {
+		return true
+	}
+	in := c.Prompt.Input("Apply? (y/N)> ")
+	if strings.ToLower(in) != "y" {
+		c.Print("Okay, not changing the configuration")
+		return false
+	}
+	return true
+}
+
+// SetConfig sets a configuration value, after showing the change
+func SetConfig(c *Clippan, args []string) error {
+	var node string
+	var force bool
+
+	fs := configFlags("config set", "config set [flags] <section> <key> <value>", &node, &force)
+	// the value may start with a -
+	positional, err := ParseInterspersedN(fs, args[1:], 2)
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(positional) < 3 {
+		fs.Usage()
+		return UsageError
+	}
+	if !c.enableWrite {
+		return ReadOnlyError
+	}
+	section, key, value := positional[0], positional[1], strings.Join(positional[2:], " ")
+
+	old, exists, err := currentConfigValue(c, node, section, key)
+	if err != nil {
+		return err
+	}
+	if exists && old == value {
+		c.Print("%s/%s is already %s", section, key, value)
+		return nil
+	}
+	if !confirmConfigChange(c, force, node, section, key, old, exists, &value) {
+		return nil
+	}
+	if _, err := c.client.SetConfigValue(context.TODO(), node, section, key, value); err != nil {
+		return err
+	}
+	c.Print("%s/%s set", section, key)
+	return nil
+}
+
+// DeleteConfig deletes a configuration key, after showing the change
+func DeleteConfig(c *Clippan, args []string) error {
+	var node string
+	var force bool
+
+	fs := configFlags("config delete", "config delete [flags] <section> <key>", &node, &force)
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		return UsageError
+	}
+	if !c.enableWrite {
+		return ReadOnlyError
+	}
+	section, key := positional[0], positional[1]
+
+	old, exists, err := currentConfigValue(c, node, section, key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%s/%s is not set", section, key)
+	}
+	if !confirmConfigChange(c, force, node, section, key, old, exists, nil) {
+		return nil
+	}
+	if _, err := c.client.DeleteConfigKey(context.TODO(), node, section, key); err != nil {
+		return err
+	}
+	c.Print("%s/%s deleted", section, key)
+	return nil
+}
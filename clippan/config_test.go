@@ -0,0 +1,56 @@
+package clippan
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig(t *testing.T) {
+	DB := helpers.DBSession("test-config")
+
+	t.Run("Test config", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("config couchdb")
+		assert.Len(printer.Errors, 0)
+		assert.Equal("[couchdb]\n", printer.Prints[0])
+
+		c.Executer("config set clippan test 1")
+		assert.Len(printer.Errors, 1)
+	}))
+	t.Run("Test config set and delete", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		prompt := NewMockPrompt().SetMockData("y")
+		c := NewTestClippan(cdb, true, printer, NewMockEditor(), prompt)
+		defer cdb.Client().DeleteConfigKey(context.TODO(), localNode, "clippan", "test") // nolint: errcheck
+
+		c.Executer("config set clippan test a value")
+		assert.Len(printer.Errors, 0)
+		assert.Contains(strings.Join(printer.Prints, "\n"), "+ test = a value")
+		value, err := cdb.Client().ConfigValue(context.TODO(), localNode, "clippan", "test")
+		assert.NoError(err)
+		assert.Equal("a value", value)
+
+		c.Executer("config set clippan test -1")
+		assert.Len(printer.Errors, 0)
+		value, err = cdb.Client().ConfigValue(context.TODO(), localNode, "clippan", "test")
+		assert.NoError(err)
+		assert.Equal("-1", value)
+
+		printer.Prints = nil
+		c.Executer("config delete clippan test")
+		assert.Len(printer.Errors, 0)
+		assert.Contains(strings.Join(printer.Prints, "\n"), "- test = -1")
+		assert.Len(prompt.Inputs, 3)
+
+		c.Executer("config delete clippan test")
+		assert.Len(printer.Errors, 1)
+	}))
+}
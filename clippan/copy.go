@@ -0,0 +1,191 @@
+package clippan
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/iivvoo/clippan/helpers"
+)
+
+var SameDocumentError = errors.New("Source and target are the same document")
+
+// splitDocRef splits a document reference into a database name and a
+// document id. References look like `id` (current database) or `db/id`.
+// Design and local documents can't be mistaken for `db/id`, so _design/x is
+// always the design document x in the current database. Whether the name is
+// really a database is up to resolveDocRef
+func splitDocRef(ref string) (string, string) {
+	if strings.HasPrefix(ref, "_design/") || strings.HasPrefix(ref, "_local/") {
+		return "", ref
+	}
+	if i := strings.Index(ref, "/"); i > 0 && i < len(ref)-1 {
+		return ref[:i], ref[i+1:]
+	}
+	return "", ref
+}
+
+// resolveDocRef returns the database and id a document reference refers to.
+// `db/id` only refers to another database if that database exists, so ids
+// containing a / can still be used in the current database
+func resolveDocRef(c *Clippan, ref string) (*kivik.DB, string, error) {
+	name, id := splitDocRef(ref)
+	if name != "" {
+		exists, err := c.client.DBExists(context.TODO(), name)
+		if err != nil {
+			return nil, "", err
+		}
+		if exists {
+			return c.client.DB(context.TODO(), name), id, nil
+		}
+		if c.database == nil {
+			return nil, "", fmt.Errorf("%s: %s", name, DatabaseDoesNotExist)
+		}
+		id = ref
+	}
+	if c.database == nil {
+		return nil, "", NoDatabaseError
+	}
+	return c.database, id, nil
+}
+
+// inlineAttachments turns attachments fetched with attachments=true into
+// attachments that can be stored in another document
+func inlineAttachments(doc map[string]interface{}) {
+	attachments, ok := doc["_attachments"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for name, a := range attachments {
+		att, _ := a.(map[string]interface{})
+		attachments[name] = map[string]interface{}{
+			"content_type": att["content_type"],
+			"data":         att["data"],
+		}
+	}
+}
+
+// copyDocument copies the document src to dst (including attachments),
+// overwriting an existing dst only if force is set. It returns the revision
+// of the source that was copied and the new revision of the target
+func copyDocument(c *Clippan, src, dst string, force bool) (string, string, error) {
+	srcDB, srcID, err := resolveDocRef(c, src)
+	if err != nil {
+		return "", "", err
+	}
+	dstDB, dstID, err := resolveDocRef(c, dst)
+	if err != nil {
+		return "", "", err
+	}
+	if srcDB.Name() == dstDB.Name() && srcID == dstID {
+		return "", "", SameDocumentError
+	}
+	partitioned := c.partitioned
+	if dstDB != c.database {
+		partitioned = IsPartitioned(dstDB)
+	}
+	if err := validPartitionedID(partitioned, dstID); err != nil {
+		return "", "", fmt.Errorf("%s: %s", dst, err)
+	}
+
+	var doc map[string]interface{}
+	row := srcDB.Get(context.TODO(), srcID, kivik.Options{"attachments": true})
+	if kivik.StatusCode(row.Err) == http.StatusNotFound {
+		return "", "", fmt.Errorf("%s: %s", src, DocumentNotFoundError)
+	} else if row.Err != nil {
+		return "", "", row.Err
+	}
+	if err := row.ScanDoc(&doc); err != nil {
+		return "", "", err
+	}
+	srcRev, _ := doc["_rev"].(string)
+	delete(doc, "_rev")
+	doc["_id"] = dstID
+	inlineAttachments(doc)
+
+	var target map[string]interface{}
+	found, err := helpers.GetOr404(dstDB, dstID, &target)
+	if err != nil {
+		return "", "", err
+	}
+	if found {
+		if !force {
+			return "", "", fmt.Errorf("%s already exists, use -f to overwrite it", dst)
+		}
+		doc["_rev"] = target["_rev"]
+	}
+
+	newRev, err := dstDB.Put(context.TODO(), dstID, doc)
+	if err != nil {
+		return "", "", err
+	}
+	return srcRev, newRev, nil
+}
+
+func copyFlags(name string, force *bool) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-f] <source> <target>\n", name)
+		fmt.Fprintf(os.Stderr, "Documents are given as id (current database) or db/id\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(force, "f", false, "Overwrite the target if it exists")
+	return fs
+}
+
+// Cp copies a document, optionally to another database
+func Cp(c *Clippan, args []string) error {
+	var force bool
+
+	fs := copyFlags(args[0], &force)
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		return UsageError
+	}
+	_, rev, err := copyDocument(c, positional[0], positional[1], force)
+	if err != nil {
+		return err
+	}
+	c.Print("Copied %s to %s (rev %s)", positional[0], positional[1], rev)
+	return nil
+}
+
+// Mv copies a document and deletes the source, optionally moving it to
+// another database
+func Mv(c *Clippan, args []string) error {
+	var force bool
+
+	fs := copyFlags(args[0], &force)
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		return UsageError
+	}
+	srcRev, rev, err := copyDocument(c, positional[0], positional[1], force)
+	if err != nil {
+		return err
+	}
+	srcDB, srcID, err := resolveDocRef(c, positional[0])
+	if err != nil {
+		return err
+	}
+	// deleting the revision that was copied makes sure we don't lose changes
+	// made in the meantime
+	if _, err := srcDB.Delete(context.TODO(), srcID, srcRev); err != nil {
+		return fmt.Errorf("copied %s to %s (rev %s), but could not delete the source: %s", positional[0], positional[1], rev, err)
+	}
+	c.Print("Moved %s to %s (rev %s)", positional[0], positional[1], rev)
+	return nil
+}
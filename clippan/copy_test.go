@@ -0,0 +1,105 @@
+package clippan
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitDocRef(t *testing.T) {
+	assert := assert.New(t)
+
+	for ref, expected := range map[string][2]string{
+		"doc1":             {"", "doc1"},
+		"orders/doc1":      {"orders", "doc1"},
+		"orders/a/b":       {"orders", "a/b"},
+		"_design/foo":      {"", "_design/foo"},
+		"_local/foo":       {"", "_local/foo"},
+		"orders/_design/x": {"orders", "_design/x"},
+		"/doc1":            {"", "/doc1"},
+		"orders/":          {"", "orders/"},
+	} {
+		db, id := splitDocRef(ref)
+		assert.Equal(expected, [2]string{db, id}, ref)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	DB := helpers.DBSession("test-copy")
+
+	t.Run("Test cp and mv", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, true, printer, NewMockEditor(), NewMockPrompt())
+		testDB := "testing-copy-target"
+		if exists, _ := cdb.Client().DBExists(context.TODO(), testDB); exists {
+			assert.NoError(cdb.Client().DestroyDB(context.TODO(), testDB))
+		}
+		assert.NoError(cdb.Client().CreateDB(context.TODO(), testDB))
+		defer cdb.Client().DestroyDB(context.TODO(), testDB) // nolint: errcheck
+
+		_, err := cdb.DB().Put(context.TODO(), "a", map[string]interface{}{"v": 1})
+		assert.NoError(err)
+		_, err = cdb.DB().Put(context.TODO(), "b", map[string]interface{}{"v": 2})
+		assert.NoError(err)
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("cp a c")
+		assert.Len(printer.Errors, 0)
+		c.Executer("cp a b")
+		assert.Len(printer.Errors, 1)
+		c.Executer("cp -f a b")
+		assert.Len(printer.Errors, 1)
+
+		var doc map[string]interface{}
+		found, err := helpers.GetOr404(cdb.DB(), "b", &doc)
+		assert.NoError(err)
+		assert.True(found)
+		assert.Equal(float64(1), doc["v"])
+
+		c.Executer("mv c " + testDB + "/c")
+		assert.Len(printer.Errors, 1)
+		found, err = helpers.GetOr404(cdb.DB(), "c", &doc)
+		assert.NoError(err)
+		assert.False(found)
+		found, err = helpers.GetOr404(cdb.Client().DB(context.TODO(), testDB), "c", &doc)
+		assert.NoError(err)
+		assert.True(found)
+
+		c.Executer("mv a a")
+		assert.Len(printer.Errors, 2)
+	}))
+	t.Run("Test cp ids with a slash and partitioned targets", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, true, printer, NewMockEditor(), NewMockPrompt())
+		testDB := "testing-copy-partitioned"
+		if exists, _ := cdb.Client().DBExists(context.TODO(), testDB); exists {
+			assert.NoError(cdb.Client().DestroyDB(context.TODO(), testDB))
+		}
+		assert.NoError(cdb.Client().CreateDB(context.TODO(), testDB, kivik.Options{"partitioned": true}))
+		defer cdb.Client().DestroyDB(context.TODO(), testDB) // nolint: errcheck
+
+		_, err := cdb.DB().Put(context.TODO(), "orders/1", map[string]interface{}{"v": 1})
+		assert.NoError(err)
+
+		c.Executer("use " + cdb.DB().Name())
+		// there is no orders database, so these are ids in the current database
+		c.Executer("cp orders/1 orders/2")
+		assert.Len(printer.Errors, 0)
+		found, err := helpers.GetOr404(cdb.DB(), "orders/2", &map[string]interface{}{})
+		assert.NoError(err)
+		assert.True(found)
+
+		c.Executer("cp orders/1 " + testDB + "/1")
+		assert.Len(printer.Errors, 1)
+		c.Executer("cp orders/1 " + testDB + "/orders:1")
+		assert.Len(printer.Errors, 1)
+		found, err = helpers.GetOr404(cdb.Client().DB(context.TODO(), testDB), "orders:1", &map[string]interface{}{})
+		assert.NoError(err)
+		assert.True(found)
+	}))
+}
@@ -0,0 +1,381 @@
+package clippan
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/gobwas/glob"
+	"github.com/iivvoo/clippan/helpers"
+)
+
+// copydbCheckpointPrefix starts the ids of the _local documents in the
+// target that record how far a copy got
+const copydbCheckpointPrefix = "_local/clippan-copydb-"
+
+var SameDatabaseError = errors.New("Source and target are the same database")
+
+// CopyCheckpoint records the progress of a copydb, so it can be resumed
+type CopyCheckpoint struct {
+	Rev      string `json:"_rev,omitempty"`
+	Source   string `json:"source"`
+	LastID   string `json:"last_id,omitempty"`
+	Bookmark string `json:"bookmark,omitempty"`
+	Listed   int    `json:"listed"`
+	Copied   int    `json:"copied"`
+	Done     bool   `json:"done,omitempty"`
+	Updated  string `json:"updated"`
+}
+
+// docRev is a document id and its current revision
+type docRev struct {
+	ID  string `json:"_id"`
+	Rev string `json:"_rev"`
+}
+
+// openDSN opens the database in a full dsn, using a client of its own so it
+// can be on another server
+func openDSN(dsn string) (*kivik.Client, *kivik.DB, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	name := strings.Trim(u.Path, "/")
+	u.Path, u.RawPath = "", ""
+	client, err := kivik.New("couch", u.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, client.DB(context.TODO(), name), nil
+}
+
+// checkpointID returns the id of the checkpoint of a copy, which depends on
+// the source and the filters used
+func checkpointID(source, idGlob, selector string) string {
+	h := sha1.Sum([]byte(source + "\n" + idGlob + "\n" + selector))
+	return copydbCheckpointPrefix + hex.EncodeToString(h[:])
+}
+
+// putLocal stores a _local document, overwriting it if it exists
+func putLocal(db *kivik.DB, id string, doc map[string]interface{}) error {
+	var existing map[string]interface{}
+	found, err := helpers.GetOr404(db, id, &existing)
+	if err != nil {
+		return err
+	}
+	delete(doc, "_rev")
+	if found {
+		doc["_rev"] = existing["_rev"]
+	}
+	_, err = db.Put(context.TODO(), id, doc)
+	return err
+}
+
+// listBatch returns the next batch of documents (id and rev) to copy,
+// continuing from the checkpoint, and tells if there are more
+func listBatch(db *kivik.DB, cp *CopyCheckpoint, selector map[string]interface{}, batch int) ([]docRev, bool, error) {
+	var docs []docRev
+	if selector != nil {
+		query := map[string]interface{}{
+			"selector": selector,
+			"fields":   []string{"_id", "_rev"},
+			"limit":    batch,
+		}
+		if cp.Bookmark != "" {
+			query["bookmark"] = cp.Bookmark
+		}
+		rows, err := db.Find(context.TODO(), query)
+		if err != nil {
+			return nil, false, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var doc docRev
+			if err := rows.ScanDoc(&doc); err != nil {
+				return nil, false, err
+			}
+			docs = append(docs, doc)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, false, err
+		}
+		cp.Bookmark = rows.Bookmark()
+	} else {
+		options := kivik.Options{"limit": batch}
+		if cp.LastID != "" {
+			options["start_key"] = cp.LastID
+			options["skip"] = 1
+		}
+		rows, err := db.AllDocs(context.TODO(), options)
+		if err != nil {
+			return nil, false, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var value struct {
+				Rev string `json:"rev"`
+			}
+			if err := rows.ScanValue(&value); err != nil {
+				return nil, false, err
+			}
+			docs = append(docs, docRev{rows.ID(), value.Rev})
+		}
+		if err := rows.Err(); err != nil {
+			return nil, false, err
+		}
+	}
+	if len(docs) > 0 {
+		cp.LastID = docs[len(docs)-1].ID
+	}
+	return docs, len(docs) == batch, nil
+}
+
+// fetchBatch gets documents including their revision history (and
+// attachments), ready to be stored with new_edits=false
+func fetchBatch(db *kivik.DB, refs []docRev, attachments bool) ([]interface{}, error) {
+	bulk := make([]kivik.BulkGetReference, len(refs))
+	for i, ref := range refs {
+		bulk[i] = kivik.BulkGetReference{ID: ref.ID, Rev: ref.Rev}
+	}
+	rows, err := db.BulkGet(context.TODO(), bulk, kivik.Options{"revs": true, "attachments": attachments})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var docs []interface{}
+	for rows.Next() {
+		var doc map[string]interface{}
+		if err := rows.ScanDoc(&doc); err != nil {
+			return nil, fmt.Errorf("%s: %s", rows.ID(), err)
+		}
+		if !attachments {
+			// stubs can't be stored without the attachments being in the target
+			delete(doc, "_attachments")
+		}
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// storeBatch stores documents as they are (new_edits=false), reporting
+// documents that could not be stored. It returns the number stored
+func storeBatch(c *Clippan, db *kivik.DB, docs []interface{}) (int, error) {
+	results, err := db.BulkDocs(context.TODO(), docs, kivik.Options{"new_edits": false})
+	if err != nil {
+		return 0, err
+	}
+	defer results.Close()
+	failed := 0
+	for results.Next() {
+		if err := results.UpdateErr(); err != nil {
+			c.Error("%s: %s", results.ID(), err)
+			failed++
+		}
+	}
+	return len(docs) - failed, results.Err()
+}
+
+// copyLocalDocs copies the _local documents, except copydb checkpoints
+func copyLocalDocs(source, target *kivik.DB) (int, error) {
+	rows, err := source.LocalDocs(context.TODO(), kivik.Options{"include_docs": true})
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	count := 0
+	for rows.Next() {
+		if strings.HasPrefix(rows.ID(), copydbCheckpointPrefix) {
+			continue
+		}
+		var doc map[string]interface{}
+		if err := rows.ScanDoc(&doc); err != nil {
+			return count, err
+		}
+		if err := putLocal(target, rows.ID(), doc); err != nil {
+			return count, fmt.Errorf("%s: %s", rows.ID(), err)
+		}
+		count++
+	}
+	return count, rows.Err()
+}
+
+// CopyDB copies documents from one database to another, possibly on another
+// server, in batches. Progress is checkpointed in the target so an
+// interrupted copy continues where it left off
+func CopyDB(c *Clippan, args []string) error {
+	var attachments, local, createTarget, restart bool
+	var idGlob, selectorArg string
+	var batch int
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: copydb [flags] <source> <target>\n")
+		fmt.Fprintf(os.Stderr, "source and target are database names on the current server or full dsns\n")
+		fmt.Fprintf(os.Stderr, "Documents keep their revision history, deleted documents are not copied\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&attachments, "attachments", false, "Copy attachments")
+	fs.BoolVar(&local, "local", false, "Copy _local documents")
+	fs.BoolVar(&createTarget, "create-target", false, "Create the target database if it doesn't exist")
+	fs.BoolVar(&restart, "restart", false, "Ignore the checkpoint of an interrupted copy and start over")
+	fs.StringVar(&idGlob, "id", "", "Only copy documents whose id matches this glob, e.g. order:*")
+	fs.StringVar(&selectorArg, "selector", "", "Only copy documents matching this Mango selector (json)")
+	fs.IntVar(&batch, "batch", 500, "Number of documents to copy at a time")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(positional) != 2 || batch <= 0 {
+		fs.Usage()
+		return UsageError
+	}
+
+	var match glob.Glob
+	if idGlob != "" {
+		if match, err = glob.Compile(idGlob); err != nil {
+			return err
+		}
+	}
+	var selector map[string]interface{}
+	if selectorArg != "" {
+		if err := json.Unmarshal([]byte(selectorArg), &selector); err != nil {
+			return fmt.Errorf("selector: %s", err)
+		}
+	}
+
+	sourceDSN, err := c.ResolveDSN(positional[0])
+	if err != nil {
+		return err
+	}
+	targetDSN, err := c.ResolveDSN(positional[1])
+	if err != nil {
+		return err
+	}
+	if sourceDSN == targetDSN {
+		return SameDatabaseError
+	}
+
+	sourceClient, source, err := openDSN(sourceDSN)
+	if err != nil {
+		return err
+	}
+	defer sourceClient.Close(context.TODO())
+	targetClient, target, err := openDSN(targetDSN)
+	if err != nil {
+		return err
+	}
+	defer targetClient.Close(context.TODO())
+
+	stats, err := source.Stats(context.TODO())
+	if err != nil {
+		return fmt.Errorf("%s: %s", redactDSN(sourceDSN), err)
+	}
+	if exists, err := targetClient.DBExists(context.TODO(), target.Name()); err != nil {
+		return err
+	} else if !exists {
+		if !createTarget {
+			return fmt.Errorf("%s: %s, use -create-target to create it", redactDSN(targetDSN), DatabaseDoesNotExist)
+		}
+		if err := targetClient.CreateDB(context.TODO(), target.Name()); err != nil {
+			return err
+		}
+	}
+
+	cpID := checkpointID(redactDSN(sourceDSN), idGlob, selectorArg)
+	cp := &CopyCheckpoint{Source: redactDSN(sourceDSN)}
+	if !restart {
+		if _, err := helpers.GetOr404(target, cpID, cp); err != nil {
+			return err
+		}
+		if cp.Done {
+			// a finished copy is done again from the start, to pick up changes
+			cp = &CopyCheckpoint{Source: redactDSN(sourceDSN)}
+		} else if cp.Copied > 0 {
+			c.Print("Resuming after %d copied documents (last id %s), use -restart to start over", cp.Copied, cp.LastID)
+		}
+	}
+	saveCheckpoint := func() error {
+		cp.Updated = time.Now().UTC().Format(time.RFC3339)
+		var doc map[string]interface{}
+		MustUnmarshal(MustMarshal(cp), &doc)
+		return putLocal(target, cpID, doc)
+	}
+
+	// the prompt doesn't handle signals while a command runs
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	copied := 0
+	for more := true; more; {
+		var refs []docRev
+		refs, more, err = listBatch(source, cp, selector, batch)
+		if err != nil {
+			break
+		}
+		cp.Listed += len(refs)
+		if match != nil {
+			var matching []docRev
+			for _, ref := range refs {
+				if match.Match(ref.ID) {
+					matching = append(matching, ref)
+				}
+			}
+			refs = matching
+		}
+		if len(refs) > 0 {
+			var docs []interface{}
+			if docs, err = fetchBatch(source, refs, attachments); err != nil {
+				break
+			}
+			var stored int
+			if stored, err = storeBatch(c, target, docs); err != nil {
+				break
+			}
+			copied += stored
+			cp.Copied += stored
+		}
+		if err = saveCheckpoint(); err != nil {
+			break
+		}
+		if selector == nil {
+			c.Progress("%s copied %d", ProgressBar(cp.Listed, int(stats.DocCount), 40), copied)
+		} else {
+			c.Progress("copied %d", copied)
+		}
+
+		select {
+		case <-interrupt:
+			c.Print("Interrupted after copying %d documents, run copydb again to resume", copied)
+			return nil
+		default:
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("copied %d documents, run copydb again to resume: %s", copied, err)
+	}
+	cp.Done = true
+	if err := saveCheckpoint(); err != nil {
+		return err
+	}
+	c.Print("Copied %d documents from %s to %s", copied, redactDSN(sourceDSN), redactDSN(targetDSN))
+
+	if local {
+		count, err := copyLocalDocs(source, target)
+		if err != nil {
+			return err
+		}
+		c.Print("Copied %d _local documents", count)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package clippan
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpointID(t *testing.T) {
+	assert := assert.New(t)
+
+	id := checkpointID("http://localhost:5984/a", "", "")
+	assert.Equal(id, checkpointID("http://localhost:5984/a", "", ""))
+	assert.Contains(id, copydbCheckpointPrefix)
+	assert.NotEqual(id, checkpointID("http://localhost:5984/b", "", ""))
+	assert.NotEqual(id, checkpointID("http://localhost:5984/a", "order:*", ""))
+	assert.NotEqual(id, checkpointID("http://localhost:5984/a", "", `{"type": "order"}`))
+}
+
+func TestCopyDB(t *testing.T) {
+	DB := helpers.DBSession("test-copydb")
+
+	t.Run("Test copydb", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, true, printer, NewMockEditor(), NewMockPrompt())
+		testDB := "testing-copydb-target"
+		if exists, _ := cdb.Client().DBExists(context.TODO(), testDB); exists {
+			assert.NoError(cdb.Client().DestroyDB(context.TODO(), testDB))
+		}
+		defer cdb.Client().DestroyDB(context.TODO(), testDB) // nolint: errcheck
+
+		for i := 0; i < 5; i++ {
+			_, err := cdb.DB().Put(context.TODO(), fmt.Sprintf("order:%d", i), map[string]interface{}{"v": i})
+			assert.NoError(err)
+		}
+		_, err := cdb.DB().Put(context.TODO(), "customer:1", map[string]interface{}{"v": 42})
+		assert.NoError(err)
+		_, err = cdb.DB().Put(context.TODO(), "_local/state", map[string]interface{}{"v": 1})
+		assert.NoError(err)
+
+		c.Executer("copydb " + cdb.DB().Name() + " " + testDB)
+		assert.Len(printer.Errors, 1)
+
+		c.Executer("copydb -create-target -batch 2 -local -id order:* " + cdb.DB().Name() + " " + testDB)
+		assert.Len(printer.Errors, 1)
+		target := cdb.Client().DB(context.TODO(), testDB)
+		stats, err := target.Stats(context.TODO())
+		assert.NoError(err)
+		assert.Equal(int64(5), stats.DocCount)
+
+		var doc map[string]interface{}
+		found, err := helpers.GetOr404(target, "_local/state", &doc)
+		assert.NoError(err)
+		assert.True(found)
+
+		assert.Contains(printer.Progresses[len(printer.Progresses)-1], "copied 5")
+
+		sourceDSN, err := c.ResolveDSN(cdb.DB().Name())
+		assert.NoError(err)
+		var cp CopyCheckpoint
+		found, err = helpers.GetOr404(target, checkpointID(redactDSN(sourceDSN), "order:*", ""), &cp)
+		assert.NoError(err)
+		assert.True(found)
+		assert.True(cp.Done)
+		assert.Equal(6, cp.Listed)
+		assert.Equal(5, cp.Copied)
+
+		// the copy was finished, so running it again starts over and picks up
+		// documents that sort before the last id
+		_, err = cdb.DB().Put(context.TODO(), "order:0a", map[string]interface{}{"v": 0})
+		assert.NoError(err)
+		printer.Prints = nil
+		c.Executer("copydb -id order:* " + cdb.DB().Name() + " " + testDB)
+		assert.Len(printer.Errors, 1)
+		assert.Len(printer.Prints, 1)
+		assert.Contains(printer.Prints[0], "Copied 6 documents")
+		found, err = helpers.GetOr404(target, "order:0a", &doc)
+		assert.NoError(err)
+		assert.True(found)
+	}))
+}
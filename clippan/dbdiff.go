@@ -0,0 +1,178 @@
+package clippan
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+// DatabaseDiff is the result of comparing two databases
+type DatabaseDiff struct {
+	OnlyA     []string `json:"only_a"`
+	OnlyB     []string `json:"only_b"`
+	Different []string `json:"different"`
+	Same      int      `json:"same"`
+}
+
+// dbDiffEntry is a row of _all_docs, with the document if it was included
+type dbDiffEntry struct {
+	ID  string
+	Rev string
+	Doc map[string]interface{}
+}
+
+// allDocsIterator returns a function that returns the next row of
+// _all_docs, or nil when done
+func allDocsIterator(rows *kivik.Rows, includeDocs bool) func() (*dbDiffEntry, error) {
+	return func() (*dbDiffEntry, error) {
+		if !rows.Next() {
+			return nil, rows.Err()
+		}
+		var value struct {
+			Rev string `json:"rev"`
+		}
+		if err := rows.ScanValue(&value); err != nil {
+			return nil, err
+		}
+		entry := &dbDiffEntry{ID: rows.ID(), Rev: value.Rev}
+		if includeDocs {
+			if err := rows.ScanDoc(&entry.Doc); err != nil {
+				return nil, err
+			}
+		}
+		return entry, nil
+	}
+}
+
+// sameContent compares documents ignoring their revision. Attachments are
+// compared by digest
+func sameContent(a, b map[string]interface{}) bool {
+	strip := func(doc map[string]interface{}) map[string]interface{} {
+		res := map[string]interface{}{}
+		for k, v := range doc {
+			res[k] = v
+		}
+		delete(res, "_rev")
+		if attachments, ok := doc["_attachments"].(map[string]interface{}); ok {
+			digests := map[string]interface{}{}
+			for name, a := range attachments {
+				att, _ := a.(map[string]interface{})
+				digests[name] = att["digest"]
+			}
+			res["_attachments"] = digests
+		}
+		return res
+	}
+	return reflect.DeepEqual(strip(a), strip(b))
+}
+
+// diffDatabases walks the rows of two databases in id order, like a merge
+// join. _all_docs uses raw (byte order) collation for ids, which is what
+// string comparison does. If content is set, documents with different
+// revisions only count as different if their bodies differ
+func diffDatabases(nextA, nextB func() (*dbDiffEntry, error), content bool) (*DatabaseDiff, error) {
+	res := &DatabaseDiff{OnlyA: []string{}, OnlyB: []string{}, Different: []string{}}
+	a, err := nextA()
+	if err != nil {
+		return nil, err
+	}
+	b, err := nextB()
+	if err != nil {
+		return nil, err
+	}
+	for a != nil || b != nil {
+		switch {
+		case b == nil || (a != nil && a.ID < b.ID):
+			res.OnlyA = append(res.OnlyA, a.ID)
+			if a, err = nextA(); err != nil {
+				return nil, err
+			}
+			continue
+		case a == nil || b.ID < a.ID:
+			res.OnlyB = append(res.OnlyB, b.ID)
+			if b, err = nextB(); err != nil {
+				return nil, err
+			}
+			continue
+		case a.Rev == b.Rev || (content && sameContent(a.Doc, b.Doc)):
+			res.Same++
+		default:
+			res.Different = append(res.Different, a.ID)
+		}
+		if a, err = nextA(); err != nil {
+			return nil, err
+		}
+		if b, err = nextB(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// DBDiff compares the documents in two databases, possibly on different servers
+func DBDiff(c *Clippan, args []string) error {
+	var content, useJson bool
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dbdiff [flags] <dbA> <dbB>\n")
+		fmt.Fprintf(os.Stderr, "Databases are names on the current server or full dsns\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&content, "content", false, "Compare document bodies (ignoring _rev) in stead of revisions")
+	fs.BoolVar(&useJson, "json", false, "Output json")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		return UsageError
+	}
+
+	var nexts [2]func() (*dbDiffEntry, error)
+	for i, name := range positional {
+		dsn, err := c.ResolveDSN(name)
+		if err != nil {
+			return err
+		}
+		client, db, err := openDSN(dsn)
+		if err != nil {
+			return err
+		}
+		defer client.Close(context.TODO())
+		rows, err := db.AllDocs(context.TODO(), kivik.Options{"include_docs": content})
+		if err != nil {
+			return fmt.Errorf("%s: %s", redactDSN(dsn), err)
+		}
+		defer rows.Close()
+		nexts[i] = allDocsIterator(rows, content)
+	}
+
+	diff, err := diffDatabases(nexts[0], nexts[1], content)
+	if err != nil {
+		return err
+	}
+	if useJson {
+		c.JSON(MustMarshal(diff))
+		return nil
+	}
+
+	color := useColor(c.Printer)
+	for _, id := range diff.OnlyA {
+		c.Print("%s", colorize(color, colorRed, "< "+id))
+	}
+	for _, id := range diff.OnlyB {
+		c.Print("%s", colorize(color, colorGreen, "> "+id))
+	}
+	for _, id := range diff.Different {
+		c.Print("%s", colorize(color, colorYellow, "~ "+id))
+	}
+	c.Print("%d only in %s, %d only in %s, %d different, %d the same",
+		len(diff.OnlyA), positional[0], len(diff.OnlyB), positional[1], len(diff.Different), diff.Same)
+	return nil
+}
@@ -0,0 +1,106 @@
+package clippan
+
+import (
+	"context"
+	"testing"
+
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func sliceIterator(entries ...*dbDiffEntry) func() (*dbDiffEntry, error) {
+	return func() (*dbDiffEntry, error) {
+		if len(entries) == 0 {
+			return nil, nil
+		}
+		e := entries[0]
+		entries = entries[1:]
+		return e, nil
+	}
+}
+
+func TestDiffDatabases(t *testing.T) {
+	assert := assert.New(t)
+
+	docA := map[string]interface{}{"_id": "c", "_rev": "1-a", "v": float64(1)}
+	docB := map[string]interface{}{"_id": "c", "_rev": "1-b", "v": float64(1)}
+	entriesA := func() func() (*dbDiffEntry, error) {
+		return sliceIterator(
+			&dbDiffEntry{ID: "a", Rev: "1-a"},
+			&dbDiffEntry{ID: "c", Rev: "1-a", Doc: docA},
+			&dbDiffEntry{ID: "d", Rev: "1-a"},
+			&dbDiffEntry{ID: "f", Rev: "1-a"},
+		)
+	}
+	entriesB := func() func() (*dbDiffEntry, error) {
+		return sliceIterator(
+			&dbDiffEntry{ID: "b", Rev: "1-a"},
+			&dbDiffEntry{ID: "c", Rev: "1-b", Doc: docB},
+			&dbDiffEntry{ID: "d", Rev: "1-a"},
+			&dbDiffEntry{ID: "e", Rev: "1-a"},
+		)
+	}
+
+	diff, err := diffDatabases(entriesA(), entriesB(), false)
+	assert.NoError(err)
+	assert.Equal(&DatabaseDiff{OnlyA: []string{"a", "f"}, OnlyB: []string{"b", "e"}, Different: []string{"c"}, Same: 1}, diff)
+
+	diff, err = diffDatabases(entriesA(), entriesB(), true)
+	assert.NoError(err)
+	assert.Equal([]string{}, diff.Different)
+	assert.Equal(2, diff.Same)
+
+	diff, err = diffDatabases(sliceIterator(), sliceIterator(), false)
+	assert.NoError(err)
+	assert.Equal(&DatabaseDiff{OnlyA: []string{}, OnlyB: []string{}, Different: []string{}}, diff)
+}
+
+func TestSameContent(t *testing.T) {
+	assert := assert.New(t)
+
+	a := map[string]interface{}{"_rev": "1-a", "_attachments": map[string]interface{}{
+		"f": map[string]interface{}{"digest": "md5-x", "revpos": float64(1)},
+	}}
+	b := map[string]interface{}{"_rev": "2-b", "_attachments": map[string]interface{}{
+		"f": map[string]interface{}{"digest": "md5-x", "revpos": float64(2)},
+	}}
+	assert.True(sameContent(a, b))
+	b["v"] = 1
+	assert.False(sameContent(a, b))
+	// a's revision isn't stripped in place
+	assert.Equal("1-a", a["_rev"])
+}
+
+func TestDBDiff(t *testing.T) {
+	DB := helpers.DBSession("test-dbdiff")
+
+	t.Run("Test dbdiff", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+		testDB := "testing-dbdiff-b"
+		if exists, _ := cdb.Client().DBExists(context.TODO(), testDB); exists {
+			assert.NoError(cdb.Client().DestroyDB(context.TODO(), testDB))
+		}
+		assert.NoError(cdb.Client().CreateDB(context.TODO(), testDB))
+		defer cdb.Client().DestroyDB(context.TODO(), testDB) // nolint: errcheck
+		other := cdb.Client().DB(context.TODO(), testDB)
+
+		for _, id := range []string{"a", "c"} {
+			_, err := cdb.DB().Put(context.TODO(), id, map[string]interface{}{"v": 1})
+			assert.NoError(err)
+		}
+		for _, id := range []string{"b", "c"} {
+			_, err := other.Put(context.TODO(), id, map[string]interface{}{"v": 1, "other": true})
+			assert.NoError(err)
+		}
+
+		c.Executer("dbdiff " + cdb.DB().Name() + " " + testDB)
+		assert.Len(printer.Errors, 0)
+		assert.Equal([]string{"< a\n", "> b\n", "~ c\n", "1 only in " + cdb.DB().Name() + ", 1 only in " + testDB + ", 1 different, 0 the same\n"}, printer.Prints)
+
+		printer.Prints = nil
+		c.Executer("dbdiff -content " + cdb.DB().Name() + " " + testDB)
+		assert.Contains(printer.Prints, "~ c\n")
+	}))
+}
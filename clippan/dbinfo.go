@@ -0,0 +1,94 @@
+package clippan
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DatabaseInfo is what GET /db returns
+type DatabaseInfo struct {
+	Name      string      `json:"db_name"`
+	DocCount  int64       `json:"doc_count"`
+	DelCount  int64       `json:"doc_del_count"`
+	UpdateSeq interface{} `json:"update_seq"`
+	PurgeSeq  interface{} `json:"purge_seq"`
+	Sizes     struct {
+		File     int64 `json:"file"`
+		External int64 `json:"external"`
+		Active   int64 `json:"active"`
+	} `json:"sizes"`
+	Cluster struct {
+		Q int `json:"q"`
+		N int `json:"n"`
+		W int `json:"w"`
+		R int `json:"r"`
+	} `json:"cluster"`
+	Props             map[string]interface{} `json:"props"`
+	CompactRunning    bool                   `json:"compact_running"`
+	InstanceStartTime string                 `json:"instance_start_time"`
+}
+
+// Info shows the information about a database
+func Info(c *Clippan, args []string) error {
+	var useJson bool
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: info [flags] [database]\n")
+		fmt.Fprintf(os.Stderr, "Shows information about the given or current database\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&useJson, "json", false, "Output json")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(positional) > 1 {
+		fs.Usage()
+		return UsageError
+	}
+	var db string
+	if len(positional) == 1 {
+		db = positional[0]
+	} else if c.database != nil {
+		db = c.database.Name()
+	} else {
+		return NoDatabaseError
+	}
+
+	stats, err := c.client.DB(context.TODO(), db).Stats(context.TODO())
+	if err != nil {
+		return err
+	}
+	if useJson {
+		c.JSON(stats.RawResponse)
+		return nil
+	}
+	info := &DatabaseInfo{}
+	if err := json.Unmarshal(stats.RawResponse, info); err != nil {
+		return err
+	}
+
+	c.Print("%s", info.Name)
+	c.Print("  documents:       %d (%d deleted)", info.DocCount, info.DelCount)
+	c.Print("  sizes:           file %s, active %s, external %s",
+		FormatSize(info.Sizes.File), FormatSize(info.Sizes.Active), FormatSize(info.Sizes.External))
+	c.Print("  update_seq:      %s", shortSeq(info.UpdateSeq))
+	c.Print("  purge_seq:       %s", shortSeq(info.PurgeSeq))
+	c.Print("  compact_running: %t", info.CompactRunning)
+	if info.Cluster.Q > 0 {
+		c.Print("  cluster:         q=%d n=%d w=%d r=%d", info.Cluster.Q, info.Cluster.N, info.Cluster.W, info.Cluster.R)
+	}
+	var props []string
+	for _, k := range sortedKeys(info.Props) {
+		props = append(props, fmt.Sprintf("%s=%v", k, info.Props[k]))
+	}
+	if len(props) > 0 {
+		c.Print("  props:           %s", strings.Join(props, ", "))
+	}
+	return nil
+}
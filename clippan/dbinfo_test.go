@@ -0,0 +1,62 @@
+package clippan
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortStats(t *testing.T) {
+	assert := assert.New(t)
+	stats := []*kivik.DBStats{
+		{Name: "b", DocCount: 1, DiskSize: 300},
+		{Name: "c", DocCount: 3, DiskSize: 100},
+		{Name: "a", DocCount: 2, DiskSize: 200},
+	}
+	names := func() string {
+		var res []string
+		for _, s := range stats {
+			res = append(res, s.Name)
+		}
+		return strings.Join(res, "")
+	}
+	sortStats(stats, "name")
+	assert.Equal("abc", names())
+	sortStats(stats, "size")
+	assert.Equal("bac", names())
+	sortStats(stats, "docs")
+	assert.Equal("cab", names())
+}
+
+func TestInfo(t *testing.T) {
+	DB := helpers.DBSession("test-info")
+
+	t.Run("Test info", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("info")
+		assert.Len(printer.Errors, 1)
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("info")
+		assert.Len(printer.Errors, 1)
+		output := strings.Join(printer.Prints, "\n")
+		assert.Contains(output, "documents:       0 (0 deleted)")
+		assert.Contains(output, "sizes:")
+	}))
+	t.Run("Test databases -sizes", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("databases -sizes -sort size " + cdb.DB().Name())
+		assert.Len(printer.Errors, 0)
+		assert.Contains(printer.Prints[0], "External")
+		assert.Contains(printer.Prints[1], cdb.DB().Name())
+	}))
+}
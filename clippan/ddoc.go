@@ -0,0 +1,576 @@
+package clippan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/tidwall/pretty"
+)
+
+/*
+ * Design document management. For editing, the (javascript) functions of
+ * a design document are unpacked from their JSON strings into separate
+ * sections so they can be edited as regular code:
+ *
+ *   { "_id": "_design/foo", "views": { "by-age": {} } }
+ *   //== views/by-age/map
+ *   function (doc) {
+ *     emit(doc.age, null);
+ *   }
+ *
+ * and packed into the JSON document again when saving
+ */
+
+// sectionPrefix starts a line that marks the start of a function section
+const sectionPrefix = "//== "
+
+// functionContainers hold functions (strings) by name
+var functionContainers = []string{"filters", "updates", "shows", "lists"}
+
+var DesignDocNotFoundError = errors.New("Design document not found")
+
+// DesignDocID returns the full id for a design document name
+func DesignDocID(name string) string {
+	if strings.HasPrefix(name, "_design/") {
+		return name
+	}
+	return "_design/" + name
+}
+
+// sectionName tells if a name can be used as a section path element
+func sectionName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "/\n")
+}
+
+// designSection is a function of a design document, by section path
+type designSection struct {
+	path string
+	code string
+}
+
+// extractFunctions returns a copy of a design document without its
+// functions, and the functions that were taken out of it
+func extractFunctions(doc map[string]interface{}) (map[string]interface{}, []designSection) {
+	// don't modify the original document
+	var header map[string]interface{}
+	MustUnmarshal(MustMarshal(doc), &header)
+
+	var sections []designSection
+	if views, ok := header["views"].(map[string]interface{}); ok {
+		for _, name := range sortedKeys(views) {
+			view, ok := views[name].(map[string]interface{})
+			if !ok || !sectionName(name) {
+				continue
+			}
+			for _, part := range []string{"map", "reduce"} {
+				// mango indexes have objects, not functions
+				if code, ok := view[part].(string); ok {
+					sections = append(sections, designSection{"views/" + name + "/" + part, code})
+					delete(view, part)
+				}
+			}
+		}
+	}
+	for _, container := range functionContainers {
+		functions, ok := header[container].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, name := range sortedKeys(functions) {
+			if code, ok := functions[name].(string); ok && sectionName(name) {
+				sections = append(sections, designSection{container + "/" + name, code})
+				delete(functions, name)
+			}
+		}
+	}
+	if code, ok := header["validate_doc_update"].(string); ok {
+		sections = append(sections, designSection{"validate_doc_update", code})
+		delete(header, "validate_doc_update")
+	}
+	return header, sections
+}
+
+// UnpackDesignDoc returns an editable representation of a design document
+// with all functions moved out of the JSON document into separate sections
+func UnpackDesignDoc(doc map[string]interface{}) []byte {
+	header, sections := extractFunctions(doc)
+
+	buf := &bytes.Buffer{}
+	buf.Write(pretty.Pretty(MustMarshal(header)))
+	for _, s := range sections {
+		buf.WriteString(sectionPrefix + s.path + "\n")
+		buf.WriteString(strings.TrimRight(s.code, "\n") + "\n")
+	}
+	return buf.Bytes()
+}
+
+// splitSections splits data into the part before the first section and
+// the sections by path, in order of appearance
+func splitSections(data []byte) ([]byte, []string, map[string]string, error) {
+	var header bytes.Buffer
+	sections := map[string]*bytes.Buffer{}
+	var order []string
+	var current *bytes.Buffer
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, sectionPrefix) {
+			path := strings.TrimSpace(strings.TrimPrefix(line, sectionPrefix))
+			if _, exists := sections[path]; exists {
+				return nil, nil, nil, fmt.Errorf("duplicate section %s", path)
+			}
+			current = &bytes.Buffer{}
+			sections[path] = current
+			order = append(order, path)
+			continue
+		}
+		if current == nil {
+			header.WriteString(line + "\n")
+		} else {
+			current.WriteString(line + "\n")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	code := make(map[string]string, len(sections))
+	for path, buf := range sections {
+		code[path] = strings.TrimRight(buf.String(), "\n")
+	}
+	return header.Bytes(), order, code, nil
+}
+
+// PackDesignDoc is the reverse of UnpackDesignDoc, it puts all function
+// sections back into the JSON document
+func PackDesignDoc(data []byte) (map[string]interface{}, error) {
+	header, order, sections, err := splitSections(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(header, &doc); err != nil {
+		return nil, fmt.Errorf("design document does not validate as json: %s", err)
+	}
+
+	for _, path := range order {
+		if err := setFunction(doc, path, sections[path]); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// setFunction stores code in the design document at the section path
+func setFunction(doc map[string]interface{}, path, code string) error {
+	parts := strings.Split(path, "/")
+	target := doc
+	for _, part := range parts[:len(parts)-1] {
+		if part == "" {
+			return fmt.Errorf("invalid section %s", path)
+		}
+		next, ok := target[part].(map[string]interface{})
+		if !ok {
+			if _, exists := target[part]; exists {
+				return fmt.Errorf("section %s conflicts with the document", path)
+			}
+			next = map[string]interface{}{}
+			target[part] = next
+		}
+		target = next
+	}
+	target[parts[len(parts)-1]] = code
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetDesignDocs returns all design documents in a database
+func GetDesignDocs(db *kivik.DB) ([]map[string]interface{}, error) {
+	rows, err := db.AllDocs(context.TODO(), kivik.Options{
+		"start_key":    "_design/",
+		"end_key":      "_design0",
+		"include_docs": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []map[string]interface{}
+	for rows.Next() {
+		var doc map[string]interface{}
+		if err := rows.ScanDoc(&doc); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// DesignDocs lists the design documents in the current database, and what's in them
+func DesignDocs(c *Clippan, args []string) error {
+	docs, err := GetDesignDocs(c.database)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		language, _ := doc["language"].(string)
+		if language == "" {
+			language = "javascript"
+		}
+		c.Print("%s (%s)", doc["_id"], language)
+
+		var views, indexes []string
+		if v, ok := doc["views"].(map[string]interface{}); ok {
+			for _, name := range sortedKeys(v) {
+				view, _ := v[name].(map[string]interface{})
+				if index, ok := view["map"].(map[string]interface{}); ok {
+					// mango index
+					fields, _ := index["fields"].(map[string]interface{})
+					indexes = append(indexes, fmt.Sprintf("%s (%s)", name, strings.Join(sortedKeys(fields), ", ")))
+					continue
+				}
+				if reduce, ok := view["reduce"].(string); ok {
+					if strings.HasPrefix(reduce, "_") {
+						name += " (reduce: " + reduce + ")"
+					} else {
+						name += " (reduce)"
+					}
+				}
+				views = append(views, name)
+			}
+		}
+		printList := func(label string, items []string) {
+			if len(items) > 0 {
+				c.Print("  %-10s %s", label+":", strings.Join(items, ", "))
+			}
+		}
+		printList("views", views)
+		printList("indexes", indexes)
+		for _, container := range functionContainers {
+			if functions, ok := doc[container].(map[string]interface{}); ok {
+				printList(container, sortedKeys(functions))
+			}
+		}
+		if _, ok := doc["validate_doc_update"]; ok {
+			c.Print("  validate_doc_update")
+		}
+	}
+	return nil
+}
+
+// DesignDoc manages a single design document through subcommands
+func DesignDoc(c *Clippan, args []string) error {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: ddoc show [-json] <name>, ddoc edit <name>,\n")
+		fmt.Fprintf(os.Stderr, "       ddoc push [-f] [-dry-run] <dir> [name...] or ddoc pull [-f] <dir> [name...]\n")
+	}
+	if len(args) < 2 {
+		usage()
+		return UsageError
+	}
+	switch args[1] {
+	case "show":
+		return ShowDesignDoc(c, args[1:])
+	case "edit":
+		return EditDesignDoc(c, args[1:])
+	case "push":
+		return PushDesignDocs(c, args[1:])
+	case "pull":
+		return PullDesignDocs(c, args[1:])
+	case "-h", "-help", "--help":
+		usage()
+		return nil
+	}
+	usage()
+	return UsageError
+}
+
+// ShowDesignDoc shows a design document with its functions unpacked
+func ShowDesignDoc(c *Clippan, args []string) error {
+	var useJson bool
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.BoolVar(&useJson, "json", false, "Output json")
+	if fs.Parse(args[1:]) != nil {
+		return nil // help will have been printed
+	}
+	if fs.NArg() != 1 {
+		return UsageError
+	}
+
+	var doc map[string]interface{}
+	found, err := helpers.GetOr404(c.database, DesignDocID(fs.Arg(0)), &doc)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return DesignDocNotFoundError
+	}
+	if useJson {
+		c.JSON(MustMarshal(doc))
+	} else {
+		c.Print("%s", strings.TrimRight(string(UnpackDesignDoc(doc)), "\n"))
+	}
+	return nil
+}
+
+// EditDesignDoc edits a design document with its functions unpacked
+func EditDesignDoc(c *Clippan, args []string) error {
+	if !c.enableWrite {
+		return ReadOnlyError
+	}
+	if len(args) != 2 {
+		return UsageError
+	}
+	id := DesignDocID(args[1])
+
+	var doc map[string]interface{}
+	found, err := helpers.GetOr404(c.database, id, &doc)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return DesignDocNotFoundError
+	}
+
+	// what the server has, to tell if anything was changed
+	unchanged := UnpackDesignDoc(doc)
+	data := unchanged
+	for {
+		edited, err := c.Editor.Edit(data)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(edited, unchanged) {
+			c.Print("No changes")
+			return nil
+		}
+		data = edited
+		packed, err := PackDesignDoc(data)
+		if err != nil {
+			in := c.Prompt.Input(err.Error() + ". (E)dit again or (A)bort?> ")
+			if strings.ToLower(in) == "a" {
+				return nil
+			}
+			continue
+		}
+		rev, err := c.database.Put(context.TODO(), id, packed)
+		if err == nil {
+			c.Print(rev)
+			return nil
+		}
+		if kivik.StatusCode(err) != http.StatusConflict {
+			return err
+		}
+
+		// like `edit`, show what conflicts and let the user decide
+		var current map[string]interface{}
+		found, err := helpers.GetOr404(c.database, id, &current)
+		if err != nil {
+			return err
+		}
+		latest := ""
+		unchanged = nil
+		if found {
+			latest, _ = current["_rev"].(string)
+			unchanged = UnpackDesignDoc(current)
+			printConflict(c, MustMarshal(current), MustMarshal(packed))
+		}
+		in := strings.ToLower(c.Prompt.Input("Conflict with rev " + latest + ". (A)bort, (F)orce or (E)dit again?> "))
+		if in == "a" {
+			return nil
+		}
+		if latest == "" {
+			delete(packed, "_rev")
+		} else {
+			packed["_rev"] = latest
+		}
+		if in == "f" {
+			if rev, err = c.database.Put(context.TODO(), id, packed); err != nil {
+				return err
+			}
+			c.Print(rev)
+			return nil
+		}
+		// edit again, based on the latest revision
+		data = UnpackDesignDoc(packed)
+	}
+}
+
+const mapTemplate = `function (doc) {
+  emit(doc._id, null);
+}`
+
+// setView sets the map and reduce function of a view in a design document,
+// keeping anything else the view has
+func setView(doc map[string]interface{}, name, mapFn, reduceFn string) {
+	views, ok := doc["views"].(map[string]interface{})
+	if !ok {
+		views = map[string]interface{}{}
+		doc["views"] = views
+	}
+	view, ok := views[name].(map[string]interface{})
+	if !ok {
+		view = map[string]interface{}{}
+		views[name] = view
+	}
+	view["map"] = mapFn
+	if reduceFn == "" {
+		delete(view, "reduce")
+	} else {
+		view["reduce"] = reduceFn
+	}
+}
+
+const reduceTemplate = `function (keys, values, rereduce) {
+  return sum(values);
+}`
+
+// MakeView creates (or edits) a single view in a design document, creating
+// the design document if it doesn't exist
+func MakeView(c *Clippan, args []string) error {
+	var reduce string
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: mkview [flags] design-doc view\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&reduce, "reduce", "", "Reduce function: _count, _sum, _stats or custom")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		return UsageError
+	}
+	switch reduce {
+	case "", "_count", "_sum", "_stats":
+	case "custom":
+		reduce = reduceTemplate
+	default:
+		return fmt.Errorf("unknown reduce %q, use _count, _sum, _stats or custom", reduce)
+	}
+	id := DesignDocID(positional[0])
+	name := positional[1]
+	if !sectionName(name) {
+		return fmt.Errorf("invalid view name %q", name)
+	}
+
+	var doc map[string]interface{}
+	found, err := helpers.GetOr404(c.database, id, &doc)
+	if err != nil {
+		return err
+	}
+	if !found {
+		c.Print("Creating design document %s", id)
+		doc = map[string]interface{}{"_id": id}
+	}
+
+	mapFn, reduceFn := mapTemplate, reduce
+	views, _ := doc["views"].(map[string]interface{})
+	if view, ok := views[name].(map[string]interface{}); ok {
+		mapFn, _ = view["map"].(string)
+		if reduceFn == "" {
+			reduceFn, _ = view["reduce"].(string)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("// View " + name + " in " + id + ". Remove the reduce section for a map-only view\n")
+	buf.WriteString(sectionPrefix + "map\n" + mapFn + "\n")
+	if reduceFn != "" {
+		buf.WriteString(sectionPrefix + "reduce\n" + reduceFn + "\n")
+	}
+	data := buf.Bytes()
+
+	var rev string
+	for {
+		data, err = c.Editor.Edit(data)
+		if err != nil {
+			return err
+		}
+		_, _, sections, err := splitSections(data)
+		if err == nil && strings.TrimSpace(sections["map"]) == "" {
+			err = errors.New("the view has no map function")
+		}
+		if err != nil {
+			in := c.Prompt.Input(err.Error() + ". (E)dit again or (A)bort?> ")
+			if strings.ToLower(in) == "a" {
+				return nil
+			}
+			continue
+		}
+
+		// only the view itself is changed, whatever else is in the design
+		// document (and in the view, like options) is left alone
+		setView(doc, name, sections["map"], sections["reduce"])
+		rev, err = c.database.Put(context.TODO(), id, doc)
+		if err == nil {
+			break
+		}
+		if kivik.StatusCode(err) != http.StatusConflict {
+			return err
+		}
+
+		var current map[string]interface{}
+		found, err := helpers.GetOr404(c.database, id, &current)
+		if err != nil {
+			return err
+		}
+		latest := ""
+		if found {
+			latest, _ = current["_rev"].(string)
+		} else {
+			current = map[string]interface{}{"_id": id}
+		}
+		in := strings.ToLower(c.Prompt.Input(id + " was changed to rev " + latest +
+			". (A)bort, (F)orce the view into it or (E)dit again?> "))
+		if in == "a" {
+			return nil
+		}
+		doc = current
+		if in == "f" {
+			setView(doc, name, sections["map"], sections["reduce"])
+			if rev, err = c.database.Put(context.TODO(), id, doc); err != nil {
+				return err
+			}
+			break
+		}
+		// edit again, it will be stored in the latest revision
+	}
+	c.Print(rev)
+
+	in := c.Prompt.Input("Query the view now? (y/N)> ")
+	if strings.ToLower(in) == "y" {
+		return Query(c, []string{"query", strings.TrimPrefix(id, "_design/"), name})
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package clippan
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+const testDesignDoc = `{
+  "_id": "_design/test",
+  "language": "javascript",
+  "views": {
+    "by-age": {
+      "map": "function (doc) {\n  emit(doc.age, null);\n}",
+      "reduce": "_count"
+    },
+    "by-name": {
+      "map": "function (doc) {\n  emit(doc.name, null);\n}"
+    }
+  },
+  "filters": {
+    "important": "function (doc, req) {\n  return doc.important;\n}"
+  },
+  "validate_doc_update": "function (newDoc, oldDoc, userCtx) {}"
+}`
+
+func TestPackDesignDoc(t *testing.T) {
+	t.Run("Test unpack", func(t *testing.T) {
+		assert := assert.New(t)
+		var doc map[string]interface{}
+		MustUnmarshal([]byte(testDesignDoc), &doc)
+
+		unpacked := string(UnpackDesignDoc(doc))
+		assert.Contains(unpacked, "//== views/by-age/map\nfunction (doc) {\n  emit(doc.age, null);\n}\n")
+		assert.Contains(unpacked, "//== views/by-age/reduce\n_count\n")
+		assert.Contains(unpacked, "//== filters/important\n")
+		assert.Contains(unpacked, "//== validate_doc_update\n")
+		assert.NotContains(unpacked, "emit(doc.age, null);\\n")
+		// the original should not be modified
+		assert.Contains(doc, "validate_doc_update")
+	})
+	t.Run("Test roundtrip", func(t *testing.T) {
+		assert := assert.New(t)
+		var doc map[string]interface{}
+		MustUnmarshal([]byte(testDesignDoc), &doc)
+
+		packed, err := PackDesignDoc(UnpackDesignDoc(doc))
+		assert.NoError(err)
+		assert.Equal(doc, packed)
+	})
+	t.Run("Test new sections", func(t *testing.T) {
+		assert := assert.New(t)
+
+		packed, err := PackDesignDoc([]byte(`{"_id": "_design/x"}
+//== views/new/map
+function (doc) { emit(doc._id, 1); }
+
+//== views/new/reduce
+_sum
+`))
+		assert.NoError(err)
+		view := packed["views"].(map[string]interface{})["new"].(map[string]interface{})
+		assert.Equal("function (doc) { emit(doc._id, 1); }", view["map"])
+		assert.Equal("_sum", view["reduce"])
+	})
+	t.Run("Test errors", func(t *testing.T) {
+		assert := assert.New(t)
+
+		_, err := PackDesignDoc([]byte("{\n//== views/x/map\nfunction(){}\n"))
+		assert.Error(err)
+		_, err = PackDesignDoc([]byte("{\"views\": 1}\n//== views/x/map\nfunction(){}\n"))
+		assert.Error(err)
+		_, err = PackDesignDoc([]byte("{}\n//== a\n1\n//== a\n2\n"))
+		assert.Error(err)
+	})
+}
+
+func TestDesignDocCommands(t *testing.T) {
+	DB := helpers.DBSession("test-ddoc")
+
+	setUp := func(cdb *helpers.CouchDB, t *testing.T) {
+		_, err := cdb.DB().Put(context.TODO(), "_design/test", testDesignDoc)
+		assert.NoError(t, err)
+	}
+
+	t.Run("Test ddocs", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		setUp(cdb, t)
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("ddocs")
+		assert.Len(printer.Errors, 0)
+		output := strings.Join(printer.Prints, "")
+		assert.Contains(output, "_design/test (javascript)")
+		assert.Contains(output, "by-age (reduce: _count), by-name")
+		assert.Contains(output, "important")
+		assert.Contains(output, "validate_doc_update")
+	}))
+	t.Run("Test ddoc edit in ro mode", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		setUp(cdb, t)
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("ddoc edit test")
+		assert.Len(printer.Errors, 1)
+	}))
+	t.Run("Test ddoc edit", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		setUp(cdb, t)
+
+		var doc map[string]interface{}
+		_, err := helpers.GetOr404(cdb.DB(), "_design/test", &doc)
+		assert.NoError(err)
+		edited := strings.Replace(string(UnpackDesignDoc(doc)), "_count", "_sum", 1)
+
+		editor := NewMockEditor().SetMockData([]byte(edited), nil)
+		c := NewTestClippan(cdb, true, printer, editor, NewMockPrompt())
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("ddoc edit test")
+		assert.Len(printer.Errors, 0)
+		assert.Contains(string(editor.GetReceived()), "//== views/by-age/reduce\n_count")
+
+		var updated map[string]interface{}
+		_, err = helpers.GetOr404(cdb.DB(), "_design/test", &updated)
+		assert.NoError(err)
+		view := updated["views"].(map[string]interface{})["by-age"].(map[string]interface{})
+		assert.Equal("_sum", view["reduce"])
+	}))
+	t.Run("Test ddoc edit without changes", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		setUp(cdb, t)
+
+		var doc map[string]interface{}
+		_, err := helpers.GetOr404(cdb.DB(), "_design/test", &doc)
+		assert.NoError(err)
+
+		editor := NewMockEditor().SetMockData(UnpackDesignDoc(doc), nil)
+		c := NewTestClippan(cdb, true, printer, editor, NewMockPrompt())
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("ddoc edit test")
+		assert.Len(printer.Errors, 0)
+		assert.Equal([]string{"No changes\n"}, printer.Prints)
+
+		var after map[string]interface{}
+		_, err = helpers.GetOr404(cdb.DB(), "_design/test", &after)
+		assert.NoError(err)
+		assert.Equal(doc["_rev"], after["_rev"])
+	}))
+	t.Run("Test ddoc edit conflict", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		setUp(cdb, t)
+
+		editor := &DesignDocConflictEditor{cdb: cdb, id: "_design/test"}
+		prompt := NewMockPrompt().SetMockData("f")
+		c := NewTestClippan(cdb, true, printer, editor, prompt)
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("ddoc edit test")
+		assert.Len(printer.Errors, 0)
+		assert.Len(prompt.Inputs, 1)
+		assert.Contains(strings.Join(printer.Prints, ""), "~ .views[\"by-age\"].reduce: \"_stats\" -> \"_sum\"")
+
+		var updated map[string]interface{}
+		_, err := helpers.GetOr404(cdb.DB(), "_design/test", &updated)
+		assert.NoError(err)
+		view := updated["views"].(map[string]interface{})["by-age"].(map[string]interface{})
+		assert.Equal("_sum", view["reduce"])
+	}))
+}
+
+// DesignDocConflictEditor changes the design document on the server while
+// it's being edited
+type DesignDocConflictEditor struct {
+	id  string
+	cdb *helpers.CouchDB
+}
+
+func (e *DesignDocConflictEditor) Edit(content []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	if _, err := helpers.GetOr404(e.cdb.DB(), e.id, &doc); err != nil {
+		return nil, err
+	}
+	doc["views"].(map[string]interface{})["by-age"].(map[string]interface{})["reduce"] = "_stats"
+	if _, err := e.cdb.DB().Put(context.TODO(), e.id, doc); err != nil {
+		return nil, err
+	}
+	return []byte(strings.Replace(string(content), "_count", "_sum", 1)), nil
+}
+
+func TestMakeView(t *testing.T) {
+	DB := helpers.DBSession("test-mkview")
+
+	t.Run("Test mkview creating a design doc", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+
+		edited := "//== map\nfunction (doc) {\n  emit(doc.type, 1);\n}\n//== reduce\n_sum\n"
+		editor := NewMockEditor().SetMockData([]byte(edited), nil)
+		prompt := NewMockPrompt().SetMockData("n")
+		c := NewTestClippan(cdb, true, printer, editor, prompt)
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("mkview -reduce _count new by-type")
+		assert.Len(printer.Errors, 0)
+		assert.Contains(string(editor.GetReceived()), "//== reduce\n_count\n")
+		// only asked to query
+		assert.Len(prompt.Inputs, 1)
+
+		var dd map[string]interface{}
+		found, err := helpers.GetOr404(cdb.DB(), "_design/new", &dd)
+		assert.NoError(err)
+		assert.True(found)
+		view := dd["views"].(map[string]interface{})["by-type"].(map[string]interface{})
+		assert.Equal("function (doc) {\n  emit(doc.type, 1);\n}", view["map"])
+		assert.Equal("_sum", view["reduce"])
+		assert.Nil(dd["version"])
+	}))
+	t.Run("Test mkview adding to a design doc and querying", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+
+		var doc map[string]interface{}
+		MustUnmarshal([]byte(testDesignDoc), &doc)
+		doc["rewrites"] = []interface{}{map[string]interface{}{"from": "/a", "to": "/b"}}
+		doc["views"].(map[string]interface{})["lib"] = map[string]interface{}{"utils": "exports.x = 1;"}
+		_, err := cdb.DB().Put(context.TODO(), "_design/test", doc)
+		assert.NoError(err)
+		_, err = cdb.DB().Put(context.TODO(), "doc1", map[string]interface{}{"type": "a"})
+		assert.NoError(err)
+
+		edited := "//== map\nfunction (doc) {\n  emit(doc.type, 1);\n}\n"
+		editor := NewMockEditor().SetMockData([]byte(edited), nil)
+		c := NewTestClippan(cdb, true, printer, editor, NewMockPrompt().SetMockData("y"))
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("mkview test by-type")
+		assert.Len(printer.Errors, 0)
+		assert.Contains(printer.Prints, "\n1 results shown\n")
+
+		var dd map[string]interface{}
+		_, err = helpers.GetOr404(cdb.DB(), "_design/test", &dd)
+		assert.NoError(err)
+		views := dd["views"].(map[string]interface{})
+		assert.Len(views, 4)
+		assert.NotContains(views["by-type"], "reduce")
+		assert.Equal("_count", views["by-age"].(map[string]interface{})["reduce"])
+		assert.Equal(map[string]interface{}{"utils": "exports.x = 1;"}, views["lib"])
+		assert.NotNil(dd["rewrites"])
+	}))
+	t.Run("Test mkview conflict", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+
+		_, err := cdb.DB().Put(context.TODO(), "_design/test", testDesignDoc)
+		assert.NoError(err)
+
+		editor := &DesignDocConflictEditor{cdb: cdb, id: "_design/test"}
+		prompt := NewMockPrompt().SetMockData("f")
+		c := NewTestClippan(cdb, true, printer, editor, prompt)
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("mkview -reduce _count test by-type")
+		assert.Len(printer.Errors, 0)
+		assert.Len(prompt.Inputs, 2)
+
+		var dd map[string]interface{}
+		_, err = helpers.GetOr404(cdb.DB(), "_design/test", &dd)
+		assert.NoError(err)
+		views := dd["views"].(map[string]interface{})
+		// the change made in the meantime is kept
+		assert.Equal("_stats", views["by-age"].(map[string]interface{})["reduce"])
+		assert.Equal("_sum", views["by-type"].(map[string]interface{})["reduce"])
+	}))
+	t.Run("Test mkview without map", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+
+		editor := NewMockEditor().SetMockData([]byte("//== reduce\n_sum\n"), nil)
+		prompt := NewMockPrompt().SetMockData("a")
+		c := NewTestClippan(cdb, true, printer, editor, prompt)
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("mkview new by-type")
+		assert.Len(prompt.Inputs, 1)
+
+		found, err := helpers.GetOr404(cdb.DB(), "_design/new", &map[string]interface{}{})
+		assert.NoError(err)
+		assert.False(found)
+	}))
+}
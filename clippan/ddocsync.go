@@ -0,0 +1,490 @@
+package clippan
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/tidwall/pretty"
+)
+
+/*
+ * Design documents on disk, in a couchapp-like layout:
+ *
+ *   <dir>/<name>/language                  optional, defaults to javascript
+ *   <dir>/<name>/version                   optional, bumped on push
+ *   <dir>/<name>/views/<view>/map.js
+ *   <dir>/<name>/views/<view>/reduce.js    optional
+ *   <dir>/<name>/filters/<filter>.js       (same for updates, shows and lists)
+ *   <dir>/<name>/validate_doc_update.js    optional
+ *   <dir>/<name>/extra.json                optional, everything else in the
+ *                                          design document (views.lib, options,
+ *                                          rewrites, mango indexes, ...)
+ */
+
+// extraFile holds the fields of a design document that aren't functions
+const extraFile = "extra.json"
+
+// safePathName tells if a (design document or function) name can be used
+// as a file or directory name without escaping its directory
+func safePathName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, "/\\\n") && !filepath.IsAbs(name)
+}
+
+// within tells if path is inside dir
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// designDocPath returns the directory of a design document in dir
+func designDocPath(dir, id string) (string, error) {
+	name := strings.TrimPrefix(id, "_design/")
+	base := filepath.Join(dir, name)
+	if !safePathName(name) || !within(dir, base) {
+		return "", fmt.Errorf("%s can't be written to disk, its name is not a valid directory name", id)
+	}
+	return base, nil
+}
+
+// versionString formats an integer version, as read from json or from disk
+func versionString(v interface{}) (string, bool) {
+	switch n := v.(type) {
+	case float64:
+		if n == float64(int(n)) {
+			return strconv.Itoa(int(n)), true
+		}
+	case int:
+		return strconv.Itoa(n), true
+	}
+	return "", false
+}
+
+// designDocFiles returns the files (relative paths using /) a design
+// document consists of on disk, and their contents
+func designDocFiles(doc map[string]interface{}) (map[string]string, error) {
+	rest, sections := extractFunctions(doc)
+	files := map[string]string{}
+	for _, s := range sections {
+		for _, part := range strings.Split(s.path, "/") {
+			if !safePathName(part) {
+				return nil, fmt.Errorf("%s: %s can't be written to disk, %q is not a valid file name", doc["_id"], s.path, part)
+			}
+		}
+		files[s.path+".js"] = s.code
+	}
+
+	delete(rest, "_id")
+	delete(rest, "_rev")
+	if language, ok := rest["language"].(string); ok {
+		if language != "javascript" {
+			files["language"] = language
+		}
+		delete(rest, "language")
+	}
+	if version, ok := versionString(rest["version"]); ok {
+		files["version"] = version
+		delete(rest, "version")
+	}
+	// the functions are written as files, don't keep what's left of their containers
+	if views, ok := rest["views"].(map[string]interface{}); ok {
+		for name, v := range views {
+			if view, ok := v.(map[string]interface{}); ok && len(view) == 0 {
+				delete(views, name)
+			}
+		}
+	}
+	for _, container := range append([]string{"views"}, functionContainers...) {
+		if m, ok := rest[container].(map[string]interface{}); ok && len(m) == 0 {
+			delete(rest, container)
+		}
+	}
+	if len(rest) > 0 {
+		files[extraFile] = strings.TrimRight(string(pretty.Pretty(MustMarshal(rest))), "\n")
+	}
+	return files, nil
+}
+
+func readCode(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\n"), nil
+}
+
+func writeCode(path, code string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(code+"\n"), 0644)
+}
+
+// readFunctions reads the *.js files in a directory into doc, below path
+func readFunctions(doc map[string]interface{}, dir, path string) error {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".js" {
+			continue
+		}
+		code, err := readCode(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		if err := setFunction(doc, path+"/"+strings.TrimSuffix(f.Name(), ".js"), code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadDesignDoc reads a single design document from its directory
+func ReadDesignDoc(dir string) (map[string]interface{}, error) {
+	doc := map[string]interface{}{}
+	id := DesignDocID(filepath.Base(dir))
+
+	if extra, err := ioutil.ReadFile(filepath.Join(dir, extraFile)); err == nil {
+		if err := json.Unmarshal(extra, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", id, extraFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	doc["_id"] = id
+
+	if language, err := readCode(filepath.Join(dir, "language")); err == nil {
+		doc["language"] = strings.TrimSpace(language)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	} else {
+		doc["language"] = "javascript"
+	}
+	if version, err := readCode(filepath.Join(dir, "version")); err == nil {
+		v, err := strconv.Atoi(strings.TrimSpace(version))
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid version %q", id, version)
+		}
+		doc["version"] = v
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	views, err := ioutil.ReadDir(filepath.Join(dir, "views"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, v := range views {
+		if !v.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, "views", v.Name(), "map.js")); err != nil {
+			return nil, fmt.Errorf("%s: view %s has no map function: %s", id, v.Name(), err)
+		}
+		if err := readFunctions(doc, filepath.Join(dir, "views", v.Name()), "views/"+v.Name()); err != nil {
+			return nil, fmt.Errorf("%s: %s", id, err)
+		}
+	}
+	for _, container := range functionContainers {
+		if err := readFunctions(doc, filepath.Join(dir, container), container); err != nil {
+			return nil, fmt.Errorf("%s: %s", id, err)
+		}
+	}
+
+	validate, err := readCode(filepath.Join(dir, "validate_doc_update.js"))
+	if err == nil {
+		doc["validate_doc_update"] = validate
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// ReadDesignDocDir reads all design documents (subdirectories) in dir
+func ReadDesignDocDir(dir string) ([]map[string]interface{}, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var docs []map[string]interface{}
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		doc, err := ReadDesignDoc(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// WriteDesignDoc writes a design document into its directory in dir. Names
+// that would end up outside of that directory are refused before anything
+// is written
+func WriteDesignDoc(dir string, doc map[string]interface{}) error {
+	id, _ := doc["_id"].(string)
+	base, err := designDocPath(dir, id)
+	if err != nil {
+		return err
+	}
+	files, err := designDocFiles(doc)
+	if err != nil {
+		return err
+	}
+	paths := map[string]string{}
+	for name := range files {
+		path := filepath.Join(base, filepath.FromSlash(name))
+		if !within(base, path) {
+			return fmt.Errorf("%s: %s would be written outside of %s", id, name, base)
+		}
+		paths[name] = path
+	}
+	for name, content := range files {
+		if err := writeCode(paths[name], content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// comparableDesignDoc returns the files of a design document as they're
+// compared by push: revisions and versions always differ
+func comparableDesignDoc(doc map[string]interface{}) (map[string]string, error) {
+	if doc == nil {
+		return map[string]string{}, nil
+	}
+	var copied map[string]interface{}
+	MustUnmarshal(MustMarshal(doc), &copied)
+	delete(copied, "version")
+	if _, ok := copied["language"]; !ok {
+		copied["language"] = "javascript"
+	}
+	return designDocFiles(copied)
+}
+
+// DiffDesignDocs returns a unified diff of the files of two design
+// documents, going from old to new. It's empty if they're the same
+func DiffDesignDocs(old, new map[string]interface{}) ([]string, error) {
+	oldFiles, err := comparableDesignDoc(old)
+	if err != nil {
+		return nil, err
+	}
+	newFiles, err := comparableDesignDoc(new)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for path := range oldFiles {
+		paths = append(paths, path)
+	}
+	for path := range newFiles {
+		if _, ok := oldFiles[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var lines []string
+	for _, path := range paths {
+		oldCode, inOld := oldFiles[path]
+		newCode, inNew := newFiles[path]
+		labelOld, labelNew := "server/"+path, "local/"+path
+		if !inOld {
+			labelOld = "/dev/null"
+		}
+		if !inNew {
+			labelNew = "/dev/null"
+		}
+		lines = append(lines, UnifiedDiff(labelOld, labelNew, oldCode, newCode, 3)...)
+	}
+	return lines, nil
+}
+
+// intField returns a numeric field of a document as an int
+func intField(doc map[string]interface{}, field string) (int, bool) {
+	switch v := doc[field].(type) {
+	case float64:
+		return int(v), true
+	case int:
+		return v, true
+	}
+	return 0, false
+}
+
+// PushDesignDocs uploads the design documents in a directory if they
+// differ from the ones in the database
+func PushDesignDocs(c *Clippan, args []string) error {
+	var force, dryRun bool
+
+	fs := flag.NewFlagSet("ddoc push", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: ddoc push [flags] <dir> [name...]\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&force, "f", false, "Don't ask for confirmation")
+	fs.BoolVar(&dryRun, "dry-run", false, "Only show what would be uploaded")
+	if fs.Parse(args[1:]) != nil {
+		return nil // help will have been printed
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return UsageError
+	}
+	if !c.enableWrite && !dryRun {
+		return ReadOnlyError
+	}
+
+	local, err := ReadDesignDocDir(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	local = filterDesignDocs(local, fs.Args()[1:])
+
+	var pending []map[string]interface{}
+	for _, doc := range local {
+		id := doc["_id"].(string)
+		var server map[string]interface{}
+		found, err := helpers.GetOr404(c.database, id, &server)
+		if err != nil {
+			return fmt.Errorf("%s: %s", id, err)
+		}
+		if !found {
+			server = nil
+		}
+		changes, err := DiffDesignDocs(server, doc)
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			c.Print("%s: up to date", id)
+			continue
+		}
+		if found {
+			c.Print("%s: changed", id)
+			doc["_rev"] = server["_rev"]
+			// make sure the version is newer, so applications that only
+			// upgrade older design documents see the change
+			serverVersion, hasVersion := intField(server, "version")
+			localVersion, _ := intField(doc, "version")
+			if hasVersion && localVersion <= serverVersion {
+				doc["version"] = serverVersion + 1
+			}
+		} else {
+			c.Print("%s: new", id)
+		}
+		printUnified(c, changes)
+		pending = append(pending, doc)
+	}
+	if len(pending) == 0 || dryRun {
+		return nil
+	}
+	if !force {
+		in := c.Prompt.Input(fmt.Sprintf("Upload %d design document(s)? (y/N)> ", len(pending)))
+		if strings.ToLower(in) != "y" {
+			c.Print("Okay, not uploading")
+			return nil
+		}
+	}
+	for _, doc := range pending {
+		id := doc["_id"].(string)
+		// the rev is the one the diff was made against, so changes made
+		// by someone else in the meantime are not overwritten
+		if _, err := c.database.Put(context.TODO(), id, doc); kivik.StatusCode(err) == http.StatusConflict {
+			c.Error("%s was not uploaded, it was changed by someone else", id)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("%s: %s", id, err)
+		}
+		if version, ok := intField(doc, "version"); ok {
+			c.Print("%s uploaded (version %d)", id, version)
+		} else {
+			c.Print("%s uploaded", id)
+		}
+	}
+	return nil
+}
+
+// PullDesignDocs writes the design documents in the database to a directory
+func PullDesignDocs(c *Clippan, args []string) error {
+	var force bool
+
+	fs := flag.NewFlagSet("ddoc pull", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: ddoc pull [flags] <dir> [name...]\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&force, "f", false, "Replace existing design document directories")
+	if fs.Parse(args[1:]) != nil {
+		return nil // help will have been printed
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return UsageError
+	}
+	dir := fs.Arg(0)
+
+	docs, err := GetDesignDocs(c.database)
+	if err != nil {
+		return err
+	}
+	for _, doc := range filterDesignDocs(docs, fs.Args()[1:]) {
+		id, _ := doc["_id"].(string)
+		// check everything can be written before removing anything
+		target, err := designDocPath(dir, id)
+		if err != nil {
+			c.Error("%s", err)
+			continue
+		}
+		if _, err := designDocFiles(doc); err != nil {
+			c.Error("%s", err)
+			continue
+		}
+		if _, err := os.Stat(target); err == nil {
+			if !force {
+				c.Print("%s: %s already exists, skipping (use -f to replace)", id, target)
+				continue
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+		if err := WriteDesignDoc(dir, doc); err != nil {
+			return err
+		}
+		c.Print("%s written to %s", id, target)
+	}
+	return nil
+}
+
+// filterDesignDocs returns the design documents matching names, or all if no names are given
+func filterDesignDocs(docs []map[string]interface{}, names []string) []map[string]interface{} {
+	if len(names) == 0 {
+		return docs
+	}
+	var res []map[string]interface{}
+	for _, doc := range docs {
+		for _, name := range names {
+			if DesignDocID(name) == doc["_id"] {
+				res = append(res, doc)
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,194 @@
+package clippan
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func testDesignDocMap() map[string]interface{} {
+	var doc map[string]interface{}
+	MustUnmarshal([]byte(`{
+  "_id": "_design/test",
+  "language": "javascript",
+  "version": 2,
+  "views": {
+    "by-age": {
+      "map": "function (doc) {\n  emit(doc.age, null);\n}",
+      "reduce": "_count",
+      "options": {"collation": "raw"}
+    },
+    "by-name": {
+      "map": "function (doc) {\n  emit(doc.name, null);\n}"
+    },
+    "lib": {
+      "utils": "exports.x = 1;"
+    }
+  },
+  "filters": {
+    "important": "function (doc, req) {\n  return doc.important;\n}"
+  },
+  "rewrites": [{"from": "/a", "to": "/b"}],
+  "validate_doc_update": "function (newDoc, oldDoc, userCtx) {}"
+}`), &doc)
+	return doc
+}
+
+func TestDesignDocDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clippan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("Test write and read", func(t *testing.T) {
+		assert := assert.New(t)
+		doc := testDesignDocMap()
+
+		assert.NoError(WriteDesignDoc(dir, doc))
+		_, err := os.Stat(filepath.Join(dir, "test", "views", "by-age", "reduce.js"))
+		assert.NoError(err)
+		_, err = os.Stat(filepath.Join(dir, "test", "views", "by-name", "reduce.js"))
+		assert.True(os.IsNotExist(err))
+		extra, err := ioutil.ReadFile(filepath.Join(dir, "test", extraFile))
+		assert.NoError(err)
+		assert.Contains(string(extra), `"utils": "exports.x = 1;"`)
+
+		docs, err := ReadDesignDocDir(dir)
+		assert.NoError(err)
+		assert.Len(docs, 1)
+		assert.Equal(MustMarshal(doc), MustMarshal(docs[0]))
+	})
+	t.Run("Test unsafe names", func(t *testing.T) {
+		assert := assert.New(t)
+		target, err := ioutil.TempDir(dir, "unsafe")
+		assert.NoError(err)
+
+		doc := testDesignDocMap()
+		doc["_id"] = "_design/.."
+		assert.Error(WriteDesignDoc(target, doc))
+
+		doc = testDesignDocMap()
+		doc["filters"] = map[string]interface{}{"..": "function () {}"}
+		assert.Error(WriteDesignDoc(target, doc))
+
+		doc = testDesignDocMap()
+		doc["views"] = map[string]interface{}{"..": map[string]interface{}{"map": "function () {}"}}
+		assert.Error(WriteDesignDoc(target, doc))
+
+		entries, err := ioutil.ReadDir(target)
+		assert.NoError(err)
+		assert.Len(entries, 0)
+	})
+	t.Run("Test diff", func(t *testing.T) {
+		assert := assert.New(t)
+		old := testDesignDocMap()
+		new := testDesignDocMap()
+
+		changes, err := DiffDesignDocs(old, new)
+		assert.NoError(err)
+		assert.Len(changes, 0)
+
+		// versions and revisions always differ
+		new["version"] = 3
+		new["_rev"] = "2-x"
+		changes, err = DiffDesignDocs(old, new)
+		assert.NoError(err)
+		assert.Len(changes, 0)
+
+		delete(new["views"].(map[string]interface{})["by-age"].(map[string]interface{}), "reduce")
+		delete(new["filters"].(map[string]interface{}), "important")
+		changes, err = DiffDesignDocs(old, new)
+		assert.NoError(err)
+		assert.Equal([]string{
+			"--- server/filters/important.js",
+			"+++ /dev/null",
+			"@@ -1,3 +0,0 @@",
+			"-function (doc, req) {",
+			"-  return doc.important;",
+			"-}",
+			"--- server/views/by-age/reduce.js",
+			"+++ /dev/null",
+			"@@ -1 +0,0 @@",
+			"-_count",
+		}, changes)
+
+		changes, err = DiffDesignDocs(nil, new)
+		assert.NoError(err)
+		assert.Contains(changes, "+++ local/views/by-name/map.js")
+	})
+}
+
+func TestDesignDocSync(t *testing.T) {
+	DB := helpers.DBSession("test-ddoc-sync")
+
+	t.Run("Test push", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		dir, err := ioutil.TempDir("", "clippan")
+		assert.NoError(err)
+		defer os.RemoveAll(dir)
+
+		assert.NoError(WriteDesignDoc(dir, testDesignDocMap()))
+
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, true, printer, NewMockEditor(), NewMockPrompt())
+		c.Executer("use " + cdb.DB().Name())
+
+		c.Executer("ddoc push -f " + dir)
+		assert.Len(printer.Errors, 0)
+		var server map[string]interface{}
+		found, err := helpers.GetOr404(cdb.DB(), "_design/test", &server)
+		assert.NoError(err)
+		assert.True(found)
+		assert.Equal(float64(2), server["version"])
+		assert.Equal(map[string]interface{}{"utils": "exports.x = 1;"}, server["views"].(map[string]interface{})["lib"])
+
+		// unchanged, nothing should be uploaded
+		c.Executer("ddoc push -f " + dir)
+		assert.Contains(printer.Prints, "_design/test: up to date\n")
+
+		// changed, but the version is not updated locally
+		printer.Prints = nil
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, "test", "views", "by-age", "reduce.js"), []byte("_sum\n"), 0644))
+		c.Executer("ddoc push -f " + dir)
+		assert.Len(printer.Errors, 0)
+		output := strings.Join(printer.Prints, "")
+		assert.Contains(output, "-_count\n")
+		assert.Contains(output, "+_sum\n")
+		server = nil
+		_, err = helpers.GetOr404(cdb.DB(), "_design/test", &server)
+		assert.NoError(err)
+		assert.Equal(float64(3), server["version"])
+		view := server["views"].(map[string]interface{})["by-age"].(map[string]interface{})
+		assert.Equal("_sum", view["reduce"])
+		assert.Equal(map[string]interface{}{"collation": "raw"}, view["options"])
+		assert.NotNil(server["rewrites"])
+	}))
+	t.Run("Test pull", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		dir, err := ioutil.TempDir("", "clippan")
+		assert.NoError(err)
+		defer os.RemoveAll(dir)
+
+		_, err = cdb.DB().Put(context.TODO(), "_design/test", testDesignDocMap())
+		assert.NoError(err)
+
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("ddoc pull " + dir)
+		assert.Len(printer.Errors, 0)
+
+		doc, err := ReadDesignDoc(filepath.Join(dir, "test"))
+		assert.NoError(err)
+		changes, err := DiffDesignDocs(testDesignDocMap(), doc)
+		assert.NoError(err)
+		assert.Len(changes, 0)
+	}))
+}
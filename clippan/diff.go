@@ -0,0 +1,477 @@
+package clippan
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/tidwall/pretty"
+)
+
+// Change operations in a structural diff
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+const (
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+	colorReset  = "\x1b[0m"
+)
+
+// Change is a single difference between two JSON values, at a jq style path
+type Change struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// keyPath appends an object key to a jq style path
+func keyPath(path, key string) string {
+	if identifier.MatchString(key) {
+		return path + "." + key
+	}
+	return path + "[" + string(MustMarshal(key)) + "]"
+}
+
+// DiffJSON returns the differences between two (unmarshalled) JSON values.
+// Objects are compared by key and arrays by index
+func DiffJSON(a, b interface{}) []Change {
+	return diffValues("", a, b, nil)
+}
+
+func diffValues(path string, a, b interface{}, changes []Change) []Change {
+	root := path
+	if root == "" {
+		root = "."
+	}
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := map[string]bool{}
+		for k := range av {
+			keys[k] = true
+		}
+		for k := range bv {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			old, inA := av[k]
+			new, inB := bv[k]
+			switch {
+			case !inA:
+				changes = append(changes, Change{Path: keyPath(path, k), Op: ChangeAdded, New: new})
+			case !inB:
+				changes = append(changes, Change{Path: keyPath(path, k), Op: ChangeRemoved, Old: old})
+			default:
+				changes = diffValues(keyPath(path, k), old, new, changes)
+			}
+		}
+		return changes
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(av):
+				changes = append(changes, Change{Path: p, Op: ChangeAdded, New: bv[i]})
+			case i >= len(bv):
+				changes = append(changes, Change{Path: p, Op: ChangeRemoved, Old: av[i]})
+			default:
+				changes = diffValues(p, av[i], bv[i], changes)
+			}
+		}
+		return changes
+	}
+	if !reflect.DeepEqual(a, b) {
+		changes = append(changes, Change{Path: root, Op: ChangeChanged, Old: a, New: b})
+	}
+	return changes
+}
+
+// Colored tells if the printer colorizes its output
+func (p *TextPrinter) Colored() bool {
+	return p.color
+}
+
+// useColor tells if output through p can contain colors
+func useColor(p Printer) bool {
+	colored, ok := p.(interface{ Colored() bool })
+	return ok && colored.Colored()
+}
+
+func colorize(color bool, code, s string) string {
+	if !color {
+		return s
+	}
+	return code + s + colorReset
+}
+
+// printChanges prints a structural diff, one line per change
+func printChanges(c *Clippan, changes []Change) {
+	color := useColor(c.Printer)
+	for _, ch := range changes {
+		switch ch.Op {
+		case ChangeAdded:
+			c.Print("%s", colorize(color, colorGreen, fmt.Sprintf("+ %s: %s", ch.Path, MustMarshal(ch.New))))
+		case ChangeRemoved:
+			c.Print("%s", colorize(color, colorRed, fmt.Sprintf("- %s: %s", ch.Path, MustMarshal(ch.Old))))
+		default:
+			c.Print("%s", colorize(color, colorYellow, fmt.Sprintf("~ %s: %s -> %s", ch.Path, MustMarshal(ch.Old), MustMarshal(ch.New))))
+		}
+	}
+}
+
+// diffLine is a line in a line based diff: ' ' (same), '-' or '+'
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// maxDiffCells limits the size of the table diffLines uses, larger changes
+// are shown as replacing all lines
+const maxDiffCells = 1 << 22
+
+// diffLines returns the shortest edit script between two lists of lines
+func diffLines(a, b []string) []diffLine {
+	// the common prefix and suffix don't need the (quadratic) table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []diffLine
+	for _, line := range a[:prefix] {
+		lines = append(lines, diffLine{' ', line})
+	}
+	lines = append(lines, lcsDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{' ', line})
+	}
+	return lines
+}
+
+// lcsDiff returns the shortest edit script using a longest common
+// subsequence table, or a replace of all lines if the table would be too big
+func lcsDiff(a, b []string) []diffLine {
+	var lines []diffLine
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			lines = append(lines, diffLine{'-', line})
+		}
+		for _, line := range b {
+			lines = append(lines, diffLine{'+', line})
+		}
+		return lines
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+// hunkRange formats the start and length of a hunk the way diff -u does
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+// splitLines splits text into lines, an empty text has none
+func splitLines(s string) []string {
+	s = strings.TrimRight(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// UnifiedDiff returns a unified diff of two texts with context lines of context
+func UnifiedDiff(labelA, labelB, a, b string, context int) []string {
+	lines := diffLines(splitLines(a), splitLines(b))
+
+	var res []string
+	for start := 0; start < len(lines); {
+		// find the next change
+		for start < len(lines) && lines[start].op == ' ' {
+			start++
+		}
+		if start == len(lines) {
+			break
+		}
+		// extend the hunk while changes are less than 2*context lines apart
+		end := start
+		for end < len(lines) {
+			if lines[end].op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].op == ' ' {
+				next++
+			}
+			if next == len(lines) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+		from := start - context
+		if from < 0 {
+			from = 0
+		}
+		to := end + context
+		if to > len(lines) {
+			to = len(lines)
+		}
+
+		// line numbers in a and b at the start of the hunk
+		startA, startB := 0, 0
+		for _, l := range lines[:from] {
+			if l.op != '+' {
+				startA++
+			}
+			if l.op != '-' {
+				startB++
+			}
+		}
+		lenA, lenB := 0, 0
+		var hunk []string
+		for _, l := range lines[from:to] {
+			if l.op != '+' {
+				lenA++
+			}
+			if l.op != '-' {
+				lenB++
+			}
+			hunk = append(hunk, string(l.op)+l.text)
+		}
+		if len(res) == 0 {
+			res = append(res, "--- "+labelA, "+++ "+labelB)
+		}
+		res = append(res, fmt.Sprintf("@@ -%s +%s @@", hunkRange(startA, lenA), hunkRange(startB, lenB)))
+		res = append(res, hunk...)
+		start = to
+	}
+	return res
+}
+
+// printUnified prints a unified diff, colored if possible
+func printUnified(c *Clippan, lines []string) {
+	color := useColor(c.Printer)
+	for i, l := range lines {
+		switch {
+		case i < 2:
+			c.Print("%s", l)
+		case strings.HasPrefix(l, "@@"):
+			c.Print("%s", colorize(color, colorCyan, l))
+		case strings.HasPrefix(l, "+"):
+			c.Print("%s", colorize(color, colorGreen, l))
+		case strings.HasPrefix(l, "-"):
+			c.Print("%s", colorize(color, colorRed, l))
+		default:
+			c.Print("%s", l)
+		}
+	}
+}
+
+// prettyDoc renders a document the way it's shown and edited, with sorted keys
+func prettyDoc(doc interface{}) string {
+	return string(pretty.Pretty(MustMarshal(doc)))
+}
+
+// getDocRev gets a document (db/id or id) at a revision, or its current
+// revision if rev is empty
+func getDocRev(c *Clippan, ref, rev string) (map[string]interface{}, error) {
+	db, id, err := resolveDocRef(c, ref)
+	if err != nil {
+		return nil, err
+	}
+	options := kivik.Options{}
+	if rev != "" {
+		options["rev"] = rev
+	}
+	row := db.Get(context.TODO(), id, options)
+	if kivik.StatusCode(row.Err) == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %s", ref, DocumentNotFoundError)
+	} else if row.Err != nil {
+		return nil, row.Err
+	}
+	var doc map[string]interface{}
+	if err := row.ScanDoc(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// stringList is a flag that can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// Diff compares two documents, or two revisions of a document
+func Diff(c *Clippan, args []string) error {
+	var unified, useJson bool
+	var ignore string
+	var revs stringList
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: diff [flags] <id1> <id2>, diff [flags] <id> -rev a [-rev b]\n")
+		fmt.Fprintf(os.Stderr, "Documents are given as id (current database) or db/id. With a single -rev, it's compared to the current revision\n")
+		fs.PrintDefaults()
+	}
+	fs.Var(&revs, "rev", "Revision to compare, can be given twice")
+	fs.BoolVar(&unified, "unified", false, "Show a unified diff of the pretty printed documents")
+	fs.BoolVar(&useJson, "json", false, "Output the changes as json")
+	fs.StringVar(&ignore, "ignore", "", "Comma separated top level fields to ignore, e.g. _rev")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+
+	var refA, refB, revA, revB string
+	switch {
+	case len(positional) == 2 && len(revs) == 0:
+		refA, refB = positional[0], positional[1]
+	case len(positional) == 1 && len(revs) == 1:
+		refA, refB, revA = positional[0], positional[0], revs[0]
+	case len(positional) == 1 && len(revs) == 2:
+		refA, refB, revA, revB = positional[0], positional[0], revs[0], revs[1]
+	default:
+		fs.Usage()
+		return UsageError
+	}
+
+	a, err := getDocRev(c, refA, revA)
+	if err != nil {
+		return err
+	}
+	b, err := getDocRev(c, refB, revB)
+	if err != nil {
+		return err
+	}
+	if ignore != "" {
+		for _, field := range strings.Split(ignore, ",") {
+			delete(a, field)
+			delete(b, field)
+		}
+	}
+
+	if unified {
+		labelA, labelB := refA, refB
+		if revA != "" {
+			labelA += "@" + revA
+		}
+		if revB != "" {
+			labelB += "@" + revB
+		}
+		lines := UnifiedDiff(labelA, labelB, prettyDoc(a), prettyDoc(b), 3)
+		if len(lines) == 0 {
+			c.Print("No differences")
+		}
+		printUnified(c, lines)
+		return nil
+	}
+
+	changes := DiffJSON(a, b)
+	if useJson {
+		if changes == nil {
+			changes = []Change{}
+		}
+		c.JSON(MustMarshal(changes))
+		return nil
+	}
+	if len(changes) == 0 {
+		c.Print("No differences")
+	}
+	printChanges(c, changes)
+	return nil
+}
+
+// printConflict shows how an edited document differs from the current
+// revision it conflicts with
+func printConflict(c *Clippan, current, edited []byte) {
+	var a, b map[string]interface{}
+	if json.Unmarshal(current, &a) != nil || json.Unmarshal(edited, &b) != nil {
+		return
+	}
+	delete(a, "_rev")
+	delete(b, "_rev")
+	changes := DiffJSON(a, b)
+	if len(changes) == 0 {
+		c.Print("Your edit is the same as the current revision")
+		return
+	}
+	c.Print("Your edit compared to the current revision:")
+	printChanges(c, changes)
+}
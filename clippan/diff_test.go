@@ -0,0 +1,103 @@
+package clippan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	var a, b map[string]interface{}
+	MustUnmarshal([]byte(`{"a": 1, "b": {"c": [1, 2], "d": "x"}, "e f": true}`), &a)
+	MustUnmarshal([]byte(`{"a": 2, "b": {"c": [1], "d": "x", "g": null}, "h": [1]}`), &b)
+
+	assert.Equal([]Change{
+		{Path: ".a", Op: ChangeChanged, Old: float64(1), New: float64(2)},
+		{Path: ".b.c[1]", Op: ChangeRemoved, Old: float64(2)},
+		{Path: ".b.g", Op: ChangeAdded, New: nil},
+		{Path: `["e f"]`, Op: ChangeRemoved, Old: true},
+		{Path: ".h", Op: ChangeAdded, New: []interface{}{float64(1)}},
+	}, DiffJSON(a, b))
+	assert.Len(DiffJSON(a, a), 0)
+	assert.Equal([]Change{{Path: ".", Op: ChangeChanged, Old: "x", New: float64(1)}}, DiffJSON("x", float64(1)))
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Len(UnifiedDiff("a", "b", "x\ny\n", "x\ny\n", 3), 0)
+	assert.Equal([]string{
+		"--- a",
+		"+++ b",
+		"@@ -1,3 +1,3 @@",
+		" 1",
+		"-2",
+		"+two",
+		" 3",
+	}, UnifiedDiff("a", "b", "1\n2\n3\n", "1\ntwo\n3\n", 1))
+
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+	b := "0\n1\n2\n3\n4\n5\n6\n7\n8\n"
+	assert.Equal([]string{
+		"--- a",
+		"+++ b",
+		"@@ -0,0 +1 @@",
+		"+0",
+		"@@ -9 +9,0 @@",
+		"-9",
+	}, UnifiedDiff("a", "b", a, b, 0))
+
+	// too big for the table, the changed lines are replaced as a whole
+	var long, changed []string
+	for i := 0; i < 3000; i++ {
+		long = append(long, fmt.Sprintf("%d", i))
+		changed = append(changed, fmt.Sprintf("x%d", i))
+	}
+	head, tail := "head\n", "\ntail\n"
+	diff := UnifiedDiff("a", "b", head+strings.Join(long, "\n")+tail, head+strings.Join(changed, "\n")+tail, 1)
+	assert.Len(diff, 3+1+3000+3000+1)
+	assert.Equal("@@ -1,3002 +1,3002 @@", diff[2])
+	assert.Equal(" head", diff[3])
+	assert.Equal("-0", diff[4])
+	assert.Equal("+x0", diff[3004])
+	assert.Equal(" tail", diff[len(diff)-1])
+}
+
+func TestDiff(t *testing.T) {
+	DB := helpers.DBSession("test-diff")
+
+	t.Run("Test diff", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		rev1, err := cdb.DB().Put(context.TODO(), "a", map[string]interface{}{"v": 1})
+		assert.NoError(err)
+		_, err = cdb.DB().Put(context.TODO(), "a", map[string]interface{}{"_rev": rev1, "v": 2})
+		assert.NoError(err)
+		_, err = cdb.DB().Put(context.TODO(), "b", map[string]interface{}{"v": 2})
+		assert.NoError(err)
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("diff -ignore _id,_rev a b")
+		assert.Len(printer.Errors, 0)
+		assert.Equal([]string{"No differences\n"}, printer.Prints)
+
+		printer.Prints = nil
+		c.Executer("diff a -rev " + rev1 + " -ignore _rev")
+		assert.Equal([]string{"~ .v: 1 -> 2\n"}, printer.Prints)
+
+		printer.Prints = nil
+		c.Executer("diff -unified a " + cdb.DB().Name() + "/b")
+		assert.Contains(strings.Join(printer.Prints, "\n"), `+  "_id": "b",`)
+
+		c.Executer("diff a doesnotexist")
+		assert.Len(printer.Errors, 1)
+	}))
+}
@@ -0,0 +1,68 @@
+package clippan
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// NormalizeDSN completes a (partial) dsn with the default scheme, host and port
+func NormalizeDSN(base string) (*url.URL, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+	if u.Host == "" {
+		u.Host = "localhost"
+	}
+	if u.Port() == "" {
+		u.Host += ":5984"
+	}
+	// path (database) can be empty
+
+	return u, nil
+}
+
+// resolveDSN returns the full url of a database, given either a full dsn or
+// a database name relative to the server
+func resolveDSN(server *url.URL, name string) (string, error) {
+	if !strings.Contains(name, "://") {
+		u := *server
+		u.Path = "/" + name
+		u.RawPath = "/" + url.PathEscape(name)
+		return u.String(), nil
+	}
+	u, err := NormalizeDSN(name)
+	if err != nil {
+		return "", err
+	}
+	if strings.Trim(u.Path, "/") == "" {
+		return "", fmt.Errorf("%s does not contain a database", redactDSN(name))
+	}
+	return u.String(), nil
+}
+
+// ResolveDSN returns the full url of a database, given either a full dsn or
+// a database name on the current connection
+func (c *Clippan) ResolveDSN(name string) (string, error) {
+	server, err := c.ServerURL()
+	if err != nil {
+		return "", err
+	}
+	return resolveDSN(server, name)
+}
+
+// redactDSN hides the password in a dsn, if any
+func redactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil {
+		return dsn
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
@@ -0,0 +1,113 @@
+package clippan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+/*
+ * Raw requests, for the endpoints that kivik doesn't provide (active tasks,
+ * scheduler, view info, node stats, ...)
+ */
+
+// HTTPError is returned by Request for non-2xx responses
+type HTTPError struct {
+	Status int
+	Err    string `json:"error"`
+	Reason string `json:"reason"`
+}
+
+func (e *HTTPError) Error() string {
+	if e.Err == "" {
+		return fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("%s: %s", e.Err, e.Reason)
+}
+
+// ServerURL returns the url of the server the client is connected to,
+// including credentials but without a database
+func (c *Clippan) ServerURL() (*url.URL, error) {
+	u, err := url.Parse(c.client.DSN())
+	if err != nil {
+		return nil, err
+	}
+	u.Path = ""
+	u.RawQuery = ""
+	return u, nil
+}
+
+// Request does a request on the server. path is relative to the server root
+// and may contain a query string. body (if not nil) is sent as JSON, the
+// JSON response is decoded into result (if not nil)
+func (c *Clippan) Request(method, path string, body, result interface{}) error {
+	u, err := c.ServerURL()
+	if err != nil {
+		return err
+	}
+	return c.RequestURL(u, method, path, body, result)
+}
+
+// RequestURL does a request on the server at u, see Request
+func (c *Clippan) RequestURL(u *url.URL, method, path string, body, result interface{}) error {
+	base := &url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host, Path: "/"}
+	ref, err := url.Parse(strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return err
+	}
+	target := base.ResolveReference(ref)
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(MustMarshal(body))
+	}
+	req, err := http.NewRequestWithContext(context.TODO(), method, target.String(), reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.Debug("%s %s", method, path)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		httpErr := &HTTPError{Status: resp.StatusCode}
+		json.Unmarshal(data, httpErr) // nolint: errcheck
+		return httpErr
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+// Get does a GET request on the server, see Request
+func (c *Clippan) Get(path string, result interface{}) error {
+	return c.Request(http.MethodGet, path, nil, result)
+}
+
+// StatusCode returns the http status of an error returned by Request or kivik
+func StatusCode(err error) int {
+	if httpErr, ok := err.(*HTTPError); ok {
+		return httpErr.Status
+	}
+	return kivik.StatusCode(err)
+}
@@ -0,0 +1,349 @@
+package clippan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * A (very) small subset of jq: path expressions only. Supported are
+ * identity (.), fields (.foo, ."foo bar", .["foo"]), indices (.[0], .[-1]),
+ * slices (.[1:3]), iteration (.[]) and the optional operator (?). Pipes
+ * between filters are handled by the pipeline, each stage being a JQFilter
+ */
+
+type jqStepKind uint8
+
+const (
+	jqField jqStepKind = iota
+	jqIndex
+	jqSlice
+	jqIterate
+)
+
+type jqStep struct {
+	kind     jqStepKind
+	field    string
+	index    int
+	from, to *int
+	optional bool
+}
+
+// JQFilter is a parsed jq-style path expression
+type JQFilter struct {
+	expr  string
+	steps []*jqStep
+}
+
+// ParseJQ parses a jq-style path expression such as `.items[].sku`
+func ParseJQ(expr string) (*JQFilter, error) {
+	expr = strings.TrimSpace(expr)
+	f := &JQFilter{expr: expr}
+
+	if !strings.HasPrefix(expr, ".") {
+		return nil, fmt.Errorf("filter %q should start with '.'", expr)
+	}
+	s := expr
+	for len(s) > 0 {
+		var step *jqStep
+		var err error
+
+		switch {
+		case s[0] == '.':
+			s = s[1:]
+			if len(s) == 0 {
+				break // identity
+			}
+			if s[0] == '[' {
+				continue // .[...] is the same as [...]
+			}
+			if s[0] == '"' {
+				var field string
+				if field, s, err = jqString(s); err != nil {
+					return nil, err
+				}
+				step = &jqStep{kind: jqField, field: field}
+			} else {
+				i := 0
+				for i < len(s) && jqIdentChar(s[i], i == 0) {
+					i++
+				}
+				if i == 0 {
+					if s[0] == '.' || s[0] == '?' {
+						return nil, fmt.Errorf("unexpected %q in filter %q", s[0], expr)
+					}
+					continue
+				}
+				step = &jqStep{kind: jqField, field: s[:i]}
+				s = s[i:]
+			}
+		case s[0] == '[':
+			if step, s, err = jqBracket(s, expr); err != nil {
+				return nil, err
+			}
+		case s[0] == '?':
+			if len(f.steps) == 0 {
+				return nil, fmt.Errorf("unexpected '?' in filter %q", expr)
+			}
+			f.steps[len(f.steps)-1].optional = true
+			s = s[1:]
+			continue
+		default:
+			return nil, fmt.Errorf("unexpected %q in filter %q", s[0], expr)
+		}
+		if step != nil {
+			f.steps = append(f.steps, step)
+		}
+	}
+	return f, nil
+}
+
+func jqIdentChar(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
+
+// jqString parses a double quoted string at the start of s, returning
+// the string and the remainder
+func jqString(s string) (string, string, error) {
+	escaped := false
+	for i := 1; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			var res string
+			if err := json.Unmarshal([]byte(s[:i+1]), &res); err != nil {
+				return "", "", fmt.Errorf("invalid string %s", s[:i+1])
+			}
+			return res, s[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string %s", s)
+}
+
+// jqBracket parses [], ["field"], [n] and [n:m] at the start of s
+func jqBracket(s, expr string) (*jqStep, string, error) {
+	s = strings.TrimSpace(s[1:])
+	if strings.HasPrefix(s, "]") {
+		return &jqStep{kind: jqIterate}, s[1:], nil
+	}
+	if strings.HasPrefix(s, "\"") {
+		field, rest, err := jqString(s)
+		if err != nil {
+			return nil, "", err
+		}
+		rest = strings.TrimSpace(rest)
+		if !strings.HasPrefix(rest, "]") {
+			return nil, "", fmt.Errorf("missing ']' in filter %q", expr)
+		}
+		return &jqStep{kind: jqField, field: field}, rest[1:], nil
+	}
+	end := strings.IndexByte(s, ']')
+	if end == -1 {
+		return nil, "", fmt.Errorf("missing ']' in filter %q", expr)
+	}
+	inner, rest := s[:end], s[end+1:]
+
+	parseInt := func(v string) (*int, error) {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return nil, nil
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid index %q in filter %q", v, expr)
+		}
+		return &i, nil
+	}
+
+	if colon := strings.IndexByte(inner, ':'); colon != -1 {
+		from, err := parseInt(inner[:colon])
+		if err != nil {
+			return nil, "", err
+		}
+		to, err := parseInt(inner[colon+1:])
+		if err != nil {
+			return nil, "", err
+		}
+		return &jqStep{kind: jqSlice, from: from, to: to}, rest, nil
+	}
+	index, err := parseInt(inner)
+	if err != nil {
+		return nil, "", err
+	}
+	if index == nil {
+		return nil, "", fmt.Errorf("empty index in filter %q", expr)
+	}
+	return &jqStep{kind: jqIndex, index: *index}, rest, nil
+}
+
+// String returns the original expression
+func (f *JQFilter) String() string {
+	return f.expr
+}
+
+// Apply runs the filter on a decoded JSON value, returning zero or more results
+func (f *JQFilter) Apply(v interface{}) ([]interface{}, error) {
+	values := []interface{}{v}
+	for _, step := range f.steps {
+		var next []interface{}
+		for _, value := range values {
+			res, err := step.apply(value)
+			if err != nil {
+				if step.optional {
+					continue
+				}
+				return nil, err
+			}
+			next = append(next, res...)
+		}
+		values = next
+	}
+	return values, nil
+}
+
+// ApplyRaw decodes raw JSON, applies the filter and returns the results
+// as raw JSON
+func (f *JQFilter) ApplyRaw(raw []byte) ([][]byte, error) {
+	v, err := jqDecode(raw)
+	if err != nil {
+		return nil, err
+	}
+	values, err := f.Apply(v)
+	if err != nil {
+		return nil, err
+	}
+	result := make([][]byte, 0, len(values))
+	for _, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+	return result, nil
+}
+
+// jqDecode decodes json while preserving number representation
+func jqDecode(raw []byte) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func jqTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func (s *jqStep) apply(v interface{}) ([]interface{}, error) {
+	switch s.kind {
+	case jqField:
+		switch t := v.(type) {
+		case nil:
+			return []interface{}{nil}, nil
+		case map[string]interface{}:
+			return []interface{}{t[s.field]}, nil
+		}
+		return nil, fmt.Errorf("Cannot index %s with %q", jqTypeName(v), s.field)
+	case jqIndex:
+		switch t := v.(type) {
+		case nil:
+			return []interface{}{nil}, nil
+		case []interface{}:
+			i := s.index
+			if i < 0 {
+				i += len(t)
+			}
+			if i < 0 || i >= len(t) {
+				return []interface{}{nil}, nil
+			}
+			return []interface{}{t[i]}, nil
+		}
+		return nil, fmt.Errorf("Cannot index %s with number", jqTypeName(v))
+	case jqSlice:
+		switch t := v.(type) {
+		case nil:
+			return []interface{}{nil}, nil
+		case []interface{}:
+			from, to := jqSliceBounds(s.from, s.to, len(t))
+			return []interface{}{t[from:to]}, nil
+		case string:
+			from, to := jqSliceBounds(s.from, s.to, len(t))
+			return []interface{}{t[from:to]}, nil
+		}
+		return nil, fmt.Errorf("Cannot slice %s", jqTypeName(v))
+	case jqIterate:
+		switch t := v.(type) {
+		case []interface{}:
+			return t, nil
+		case map[string]interface{}:
+			// go maps are unordered, sort by key for stable output
+			keys := make([]string, 0, len(t))
+			for k := range t {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			res := make([]interface{}, 0, len(t))
+			for _, k := range keys {
+				res = append(res, t[k])
+			}
+			return res, nil
+		}
+		return nil, fmt.Errorf("Cannot iterate over %s", jqTypeName(v))
+	}
+	return nil, fmt.Errorf("unknown filter step")
+}
+
+func jqSliceBounds(from, to *int, length int) (int, int) {
+	clamp := func(p *int, def int) int {
+		if p == nil {
+			return def
+		}
+		i := *p
+		if i < 0 {
+			i += length
+		}
+		if i < 0 {
+			return 0
+		}
+		if i > length {
+			return length
+		}
+		return i
+	}
+	f, t := clamp(from, 0), clamp(to, length)
+	if t < f {
+		t = f
+	}
+	return f, t
+}
@@ -0,0 +1,70 @@
+package clippan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJQ(t *testing.T) {
+	doc := []byte(`{"_id": "order1", "items": [{"sku": "a1", "qty": 2}, {"sku": "b2", "qty": 10000000000000001}], "meta data": {"x": 1}}`)
+
+	apply := func(expr string) ([]string, error) {
+		f, err := ParseJQ(expr)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := f.ApplyRaw(doc)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]string, 0, len(raw))
+		for _, r := range raw {
+			res = append(res, string(r))
+		}
+		return res, nil
+	}
+
+	t.Run("Test path expressions", func(t *testing.T) {
+		assert := assert.New(t)
+
+		for expr, expected := range map[string][]string{
+			".":                 {string(MustMarshal(MustDecode(doc)))},
+			"._id":              {`"order1"`},
+			".items[].sku":      {`"a1"`, `"b2"`},
+			".items[-1].qty":    {`10000000000000001`},
+			".items[5]":         {`null`},
+			".items[1:].[].sku": {`"b2"`},
+			`."meta data".x`:    {`1`},
+			`.["meta data"].x`:  {`1`},
+			".missing.deeper":   {`null`},
+			"._id[]?":           {},
+		} {
+			res, err := apply(expr)
+			assert.NoError(err, expr)
+			assert.Equal(expected, res, expr)
+		}
+	})
+	t.Run("Test errors", func(t *testing.T) {
+		assert := assert.New(t)
+
+		_, err := apply("items")
+		assert.Error(err)
+		_, err = apply(".items[")
+		assert.Error(err)
+		_, err = apply("._id.foo")
+		assert.Error(err)
+		_, err = apply(".items[]")
+		assert.NoError(err)
+		_, err = apply("._id[]")
+		assert.Error(err)
+	})
+}
+
+func MustDecode(raw []byte) interface{} {
+	v, err := jqDecode(raw)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
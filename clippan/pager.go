@@ -0,0 +1,98 @@
+package clippan
+
+import (
+	"bytes"
+	"os"
+	"strings"
+)
+
+// Pager settings
+const (
+	PagerOff  = "off"
+	PagerOn   = "on"
+	PagerAuto = "auto"
+)
+
+// defaultPager is used if $PAGER is not set
+const defaultPager = "less -R"
+
+// defaultPageSize is used for interactive paging if the terminal height is unknown
+const defaultPageSize = 20
+
+// Flusher is implemented by Printers that buffer their output
+type Flusher interface {
+	Flush() error
+}
+
+// PagerPrinter buffers output and, when flushed, shows it through $PAGER
+// if the pager is on, or if it's auto and the output does not fit the terminal
+type PagerPrinter struct {
+	*TextPrinter
+	buf  *bytes.Buffer
+	mode string
+}
+
+func NewPagerPrinter(mode string, debug bool) *PagerPrinter {
+	buf := &bytes.Buffer{}
+	return &PagerPrinter{
+		TextPrinter: NewTextPrinter(buf, true, debug),
+		buf:         buf,
+		mode:        mode,
+	}
+}
+
+func (p *PagerPrinter) Flush() error {
+	if p.buf.Len() == 0 {
+		return nil
+	}
+	data := make([]byte, p.buf.Len())
+	copy(data, p.buf.Bytes())
+	p.buf.Reset()
+
+	height := terminalHeight()
+	if p.mode == PagerOn || (p.mode == PagerAuto && height > 0 && bytes.Count(data, []byte("\n")) >= height) {
+		return runShell(pagerCommand(), data, os.Stdout)
+	}
+	_, err := os.Stdout.Write(data)
+	return err
+}
+
+func pagerCommand() string {
+	if pager := os.Getenv("PAGER"); pager != "" {
+		return pager
+	}
+	return defaultPager
+}
+
+// pageSize returns the amount of rows to fetch per page in interactive paging mode
+func pageSize() int {
+	// leave some room for headers and the prompt
+	if height := terminalHeight() - 4; height > 0 {
+		return height
+	}
+	return defaultPageSize
+}
+
+// NextPage shows pending output and asks the user if the next page should be fetched
+func (c *Clippan) NextPage() bool {
+	c.Flush()
+	in := c.Prompt.Input("-- (N)ext page or (Q)uit> ")
+	return strings.ToLower(strings.TrimSpace(in)) != "q"
+}
+
+func Pager(c *Clippan, args []string) error {
+	if len(args) == 1 {
+		c.Print("pager is %s", c.pager)
+		return nil
+	}
+	if len(args) != 2 {
+		return UsageError
+	}
+	switch mode := strings.ToLower(args[1]); mode {
+	case PagerOn, PagerOff, PagerAuto:
+		c.pager = mode
+	default:
+		return UsageError
+	}
+	return nil
+}
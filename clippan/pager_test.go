@@ -0,0 +1,37 @@
+package clippan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPager(t *testing.T) {
+	t.Run("Test pager setting", func(t *testing.T) {
+		assert := assert.New(t)
+		p := &TestPrinter{}
+		c := &Clippan{Printer: p, pager: PagerAuto}
+
+		c.Executer("pager")
+		assert.Equal([]string{"pager is auto\n"}, p.Prints)
+
+		c.Executer("pager off")
+		assert.Equal(PagerOff, c.pager)
+		c.Executer("pager ON")
+		assert.Equal(PagerOn, c.pager)
+
+		c.Executer("pager sometimes")
+		assert.Len(p.Errors, 1)
+		assert.Equal(PagerOn, c.pager)
+	})
+	t.Run("Test NextPage", func(t *testing.T) {
+		assert := assert.New(t)
+		prompt := NewMockPrompt()
+		c := &Clippan{Printer: &TestPrinter{}, Prompt: prompt}
+
+		assert.True(c.NextPage())
+		prompt.SetMockData("q")
+		assert.False(c.NextPage())
+		assert.Len(prompt.Inputs, 2)
+	})
+}
@@ -0,0 +1,77 @@
+package clippan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"strings"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+var PartitionedIDError = errors.New("Document ids in a partitioned database must look like <partition>:<id>")
+
+// IsPartitioned tells if db is a partitioned database
+func IsPartitioned(db *kivik.DB) bool {
+	stats, err := db.Stats(context.TODO())
+	if err != nil {
+		return false
+	}
+	var info struct {
+		Props struct {
+			Partitioned bool `json:"partitioned"`
+		} `json:"props"`
+	}
+	if err := json.Unmarshal(stats.RawResponse, &info); err != nil {
+		return false
+	}
+	return info.Props.Partitioned
+}
+
+// ValidatePartitionedID checks if a document id is valid for the current
+// database: in partitioned databases, all but design and local documents
+// need a partition prefix
+func ValidatePartitionedID(c *Clippan, id string) error {
+	return validPartitionedID(c.partitioned, id)
+}
+
+// validPartitionedID checks if a document id is valid for a database that
+// may be partitioned
+func validPartitionedID(partitioned bool, id string) error {
+	if !partitioned || strings.HasPrefix(id, "_design/") || strings.HasPrefix(id, "_local/") {
+		return nil
+	}
+	if i := strings.Index(id, ":"); i <= 0 || i == len(id)-1 {
+		return PartitionedIDError
+	}
+	return nil
+}
+
+// Partition shows information about a partition in the current database
+func Partition(c *Clippan, args []string) error {
+	var useJson bool
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.BoolVar(&useJson, "json", false, "Output json")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(positional) != 1 {
+		return UsageError
+	}
+
+	stats, err := c.database.PartitionStats(context.TODO(), positional[0])
+	if err != nil {
+		return err
+	}
+	if useJson {
+		c.JSON(stats.RawResponse)
+		return nil
+	}
+	c.Print("%s in %s", stats.Partition, stats.DBName)
+	c.Print("  documents: %d (%d deleted)", stats.DocCount, stats.DeletedDocCount)
+	c.Print("  sizes:     active %s, external %s", FormatSize(stats.ActiveSize), FormatSize(stats.ExternalSize))
+	return nil
+}
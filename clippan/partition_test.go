@@ -0,0 +1,62 @@
+package clippan
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePartitionedID(t *testing.T) {
+	assert := assert.New(t)
+	c := &Clippan{partitioned: true}
+	assert.NoError(ValidatePartitionedID(c, "p:doc"))
+	assert.NoError(ValidatePartitionedID(c, "_design/x"))
+	assert.NoError(ValidatePartitionedID(c, "_local/x"))
+	assert.Equal(PartitionedIDError, ValidatePartitionedID(c, "doc"))
+	assert.Equal(PartitionedIDError, ValidatePartitionedID(c, ":doc"))
+	assert.Equal(PartitionedIDError, ValidatePartitionedID(c, "p:"))
+
+	c.partitioned = false
+	assert.NoError(ValidatePartitionedID(c, "doc"))
+}
+
+func TestPartitioned(t *testing.T) {
+	DB := helpers.DBSession("test-partitioned")
+
+	t.Run("Test partitioned database", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		editor := NewMockEditor().SetMockData([]byte(`{"name": "x"}`), nil)
+		c := NewTestClippan(cdb, true, printer, editor, NewMockPrompt())
+		testDB := "testing-partitioned-createdb"
+		if exists, _ := cdb.Client().DBExists(context.TODO(), testDB); exists {
+			assert.NoError(cdb.Client().DestroyDB(context.TODO(), testDB))
+		}
+		defer cdb.Client().DestroyDB(context.TODO(), testDB) // nolint: errcheck
+
+		c.Executer("createdb -partitioned " + testDB)
+		assert.Len(printer.Errors, 0)
+		assert.True(c.partitioned)
+
+		c.Executer("put nopartition")
+		assert.Len(printer.Errors, 1)
+		c.Executer("put a:doc1")
+		c.Executer("put b:doc1")
+		assert.Len(printer.Errors, 1)
+
+		printer.Prints = nil
+		c.Executer("all -partition a")
+		assert.Len(printer.Errors, 1)
+		output := strings.Join(printer.Prints, "\n")
+		assert.Contains(output, "a:doc1")
+		assert.NotContains(output, "b:doc1")
+
+		printer.Prints = nil
+		c.Executer("partition a")
+		assert.Len(printer.Errors, 1)
+		assert.Contains(strings.Join(printer.Prints, "\n"), "documents: 1 (0 deleted)")
+	}))
+}
@@ -0,0 +1,220 @@
+package clippan
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/mattn/go-shellwords"
+)
+
+/*
+ * Pipelines: `get foo | .items[] | .sku | less`
+ *
+ * Stages starting with a '.' are jq-style filters which are applied to the
+ * JSON output of the command, unless they're a path like ./script.sh or
+ * don't parse as a filter but name an existing file. The first stage that
+ * isn't a filter (and everything after it) is handed to the shell, with the
+ * (uncolored) output of the command as its input.
+ *
+ * The line may end in `> file` or `>> file` to write (append) the final
+ * output to a file in stead of the terminal. Only the last stage can
+ * redirect, and `2>` or `>&2` are left to the shell.
+ */
+
+// splitPipeline splits a command line on unquoted '|' characters
+func splitPipeline(s string) []string {
+	var parts []string
+	var singleQuoted, doubleQuoted, escaped bool
+
+	start := 0
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && !singleQuoted:
+			escaped = true
+		case r == '\'' && !doubleQuoted:
+			singleQuoted = !singleQuoted
+		case r == '"' && !singleQuoted:
+			doubleQuoted = !doubleQuoted
+		case r == '|' && !singleQuoted && !doubleQuoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// fdRedirect tells if the '>' at i is part of a shell redirect of a
+// specific file descriptor, like `2>/dev/null`, `>&2` or `&>file`
+func fdRedirect(s string, i int) bool {
+	if strings.HasPrefix(s[i+1:], "&") || (i > 0 && s[i-1] == '&') {
+		return true
+	}
+	j := i
+	for j > 0 && s[j-1] >= '0' && s[j-1] <= '9' {
+		j--
+	}
+	return j < i && (j == 0 || s[j-1] == ' ' || s[j-1] == '\t')
+}
+
+// splitRedirect splits a trailing `> file` or `>> file` from a command line
+func splitRedirect(s string) (string, string, bool, error) {
+	var singleQuoted, doubleQuoted, escaped bool
+
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && !singleQuoted:
+			escaped = true
+		case r == '\'' && !doubleQuoted:
+			singleQuoted = !singleQuoted
+		case r == '"' && !singleQuoted:
+			doubleQuoted = !doubleQuoted
+		case r == '>' && !singleQuoted && !doubleQuoted:
+			if fdRedirect(s, i) {
+				continue
+			}
+			rest := s[i+1:]
+			appendMode := strings.HasPrefix(rest, ">")
+			if appendMode {
+				rest = rest[1:]
+			}
+			target, err := shellwords.Parse(rest)
+			if err != nil {
+				return "", "", false, err
+			}
+			if len(target) != 1 {
+				return "", "", false, fmt.Errorf("redirect expects a single filename")
+			}
+			return s[:i], target[0], appendMode, nil
+		}
+	}
+	return s, "", false, nil
+}
+
+// Pipeline is a parsed command line: the command, its filters,
+// an optional shell command to pipe the output through and an
+// optional file to redirect the output to
+type Pipeline struct {
+	Command  string
+	Filters  []*JQFilter
+	Shell    string
+	Redirect string
+	Append   bool
+}
+
+// ParsePipeline parses a command line into a Pipeline
+func ParsePipeline(s string) (*Pipeline, error) {
+	parts := splitPipeline(s)
+	last, redirect, appendMode, err := splitRedirect(parts[len(parts)-1])
+	if err != nil {
+		return nil, err
+	}
+	parts[len(parts)-1] = last
+	p := &Pipeline{Command: parts[0], Redirect: redirect, Append: appendMode}
+
+	for i, part := range parts[1:] {
+		f, err := parseFilter(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			p.Shell = strings.Join(parts[i+1:], "|")
+			break
+		}
+		p.Filters = append(p.Filters, f)
+	}
+	return p, nil
+}
+
+// parseFilter parses a stage as a jq-style filter. It returns nil if the
+// stage is a shell command, like ./script.sh or a file that exists
+func parseFilter(stage string) (*JQFilter, error) {
+	if !strings.HasPrefix(stage, ".") || strings.HasPrefix(stage, "./") || strings.HasPrefix(stage, "../") {
+		return nil, nil
+	}
+	f, err := ParseJQ(stage)
+	if err != nil {
+		if words, _ := shellwords.Parse(stage); len(words) > 0 {
+			if _, statErr := os.Stat(words[0]); statErr == nil {
+				return nil, nil
+			}
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// FilterPrinter applies jq-style filters to JSON output before handing
+// it to the wrapped Printer. Other output is passed as-is
+type FilterPrinter struct {
+	Printer
+	filters []*JQFilter
+}
+
+func NewFilterPrinter(p Printer, filters []*JQFilter) *FilterPrinter {
+	return &FilterPrinter{Printer: p, filters: filters}
+}
+
+func (f *FilterPrinter) JSON(raw []byte) {
+	results := [][]byte{raw}
+	for _, filter := range f.filters {
+		var next [][]byte
+		for _, r := range results {
+			filtered, err := filter.ApplyRaw(r)
+			if err != nil {
+				f.Printer.Error("%s: %s", filter, err.Error())
+				return
+			}
+			next = append(next, filtered...)
+		}
+		results = next
+	}
+	for _, r := range results {
+		f.Printer.JSON(r)
+	}
+}
+
+// Colored tells if the wrapped Printer colors its output
+func (f *FilterPrinter) Colored() bool {
+	return useColor(f.Printer)
+}
+
+// Progress shows progress through the wrapped Printer, if it supports it
+func (f *FilterPrinter) Progress(format string, args ...interface{}) {
+	if p, ok := f.Printer.(ProgressPrinter); ok {
+		p.Progress(format, args...)
+	}
+}
+
+// Flush flushes the wrapped Printer, if it buffers
+func (f *FilterPrinter) Flush() error {
+	if flusher, ok := f.Printer.(Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// OpenRedirect opens the file output should be redirected to
+func (p *Pipeline) OpenRedirect() (*os.File, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if p.Append {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	return os.OpenFile(p.Redirect, flags, 0644)
+}
+
+// runShell runs cmd through the shell, feeding it input and writing to out
+func runShell(cmd string, input []byte, out io.Writer) error {
+	sh := exec.Command("sh", "-c", cmd)
+	sh.Stdin = bytes.NewReader(input)
+	sh.Stdout = out
+	sh.Stderr = os.Stderr
+	return sh.Run()
+}
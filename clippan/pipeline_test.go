@@ -0,0 +1,116 @@
+package clippan
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeline(t *testing.T) {
+	t.Run("Test splitPipeline", func(t *testing.T) {
+		assert := assert.New(t)
+
+		assert.Equal([]string{"get foo"}, splitPipeline("get foo"))
+		assert.Equal([]string{"get foo ", " .items[] ", " less"}, splitPipeline("get foo | .items[] | less"))
+		assert.Equal([]string{`get "a|b" `, ` .["c|d"]`}, splitPipeline(`get "a|b" | .["c|d"]`))
+		assert.Equal([]string{`get 'a|b' a\|b`}, splitPipeline(`get 'a|b' a\|b`))
+	})
+	t.Run("Test ParsePipeline", func(t *testing.T) {
+		assert := assert.New(t)
+
+		p, err := ParsePipeline("get foo | .items[] | .sku | grep x | wc -l")
+		assert.NoError(err)
+		assert.Equal("get foo ", p.Command)
+		assert.Len(p.Filters, 2)
+		assert.Equal(" grep x | wc -l", p.Shell)
+
+		_, err = ParsePipeline("get foo | .items[")
+		assert.Error(err)
+
+		p, err = ParsePipeline("get foo | ./script.sh | .x")
+		assert.NoError(err)
+		assert.Len(p.Filters, 0)
+		assert.Equal(" ./script.sh | .x", p.Shell)
+
+		p, err = ParsePipeline("get foo | .x | ../bin/tool -a")
+		assert.NoError(err)
+		assert.Len(p.Filters, 1)
+		assert.Equal(" ../bin/tool -a", p.Shell)
+	})
+	t.Run("Test FilterPrinter", func(t *testing.T) {
+		assert := assert.New(t)
+
+		p, err := ParsePipeline("get foo | .items[] | .sku")
+		assert.NoError(err)
+		printer := &TestPrinter{}
+		f := NewFilterPrinter(printer, p.Filters)
+		f.Print("not json")
+		f.JSON([]byte(`{"items": [{"sku": "a"}, {"sku": "b"}]}`))
+
+		assert.Equal([]string{"not json\n"}, printer.Prints)
+		assert.Equal([][]byte{[]byte(`"a"`), []byte(`"b"`)}, printer.JSONS)
+
+		f.JSON([]byte(`[1, 2]`))
+		assert.Len(printer.Errors, 1)
+
+		assert.False(useColor(f))
+		assert.True(useColor(NewFilterPrinter(NewTextPrinter(&bytes.Buffer{}, true, false), p.Filters)))
+	})
+	t.Run("Test splitRedirect", func(t *testing.T) {
+		assert := assert.New(t)
+
+		cmd, file, appendMode, err := splitRedirect("get mydoc > mydoc.json")
+		assert.NoError(err)
+		assert.Equal("get mydoc ", cmd)
+		assert.Equal("mydoc.json", file)
+		assert.False(appendMode)
+
+		cmd, file, appendMode, err = splitRedirect(`query a b -json >> "my out.json"`)
+		assert.NoError(err)
+		assert.Equal("query a b -json ", cmd)
+		assert.Equal("my out.json", file)
+		assert.True(appendMode)
+
+		cmd, file, _, err = splitRedirect(`get "a>b" | .x`)
+		assert.NoError(err)
+		assert.Equal(`get "a>b" | .x`, cmd)
+		assert.Equal("", file)
+
+		_, _, _, err = splitRedirect("get a > b c")
+		assert.Error(err)
+		_, _, _, err = splitRedirect("get a >")
+		assert.Error(err)
+	})
+	t.Run("Test ParsePipeline with redirect", func(t *testing.T) {
+		assert := assert.New(t)
+
+		p, err := ParsePipeline("get foo | .items[] > out.json")
+		assert.NoError(err)
+		assert.Equal("get foo ", p.Command)
+		assert.Len(p.Filters, 1)
+		assert.Equal("out.json", p.Redirect)
+
+		// redirects of the shell stages are left to the shell
+		p, err = ParsePipeline("get foo | grep x 2>/dev/null | wc -l")
+		assert.NoError(err)
+		assert.Equal(" grep x 2>/dev/null | wc -l", p.Shell)
+		assert.Equal("", p.Redirect)
+
+		p, err = ParsePipeline("get foo | grep x 2>/dev/null >&2")
+		assert.NoError(err)
+		assert.Equal(" grep x 2>/dev/null >&2", p.Shell)
+		assert.Equal("", p.Redirect)
+
+		p, err = ParsePipeline("get foo > a | b")
+		assert.NoError(err)
+		assert.Equal("get foo > a ", p.Command)
+		assert.Equal(" b", p.Shell)
+		assert.Equal("", p.Redirect)
+
+		p, err = ParsePipeline("get foo | sort > out.txt")
+		assert.NoError(err)
+		assert.Equal(" sort ", p.Shell)
+		assert.Equal("out.txt", p.Redirect)
+	})
+}
@@ -67,8 +67,18 @@ func (p *Prompt) Input(s string) string {
 	)
 }
 
+// Password requests input without echoing it
+func (p *Prompt) Password(s string) string {
+	password, err := readPassword(s)
+	if err != nil {
+		return ""
+	}
+	return password
+}
+
 type Prompter interface {
 	GetInput(func(string))
 	SetPrompt(string)
 	Input(string) string
+	Password(string) string
 }
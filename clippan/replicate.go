@@ -0,0 +1,226 @@
+package clippan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/iivvoo/clippan/helpers"
+)
+
+const replicatorDB = "_replicator"
+
+var ReplicationNotFoundError = errors.New("Replication not found")
+
+// SchedulerDoc is a replication document as listed by _scheduler/docs
+type SchedulerDoc struct {
+	Database    string      `json:"database"`
+	DocID       string      `json:"doc_id"`
+	ID          string      `json:"id"`
+	Node        string      `json:"node"`
+	Source      string      `json:"source"`
+	Target      string      `json:"target"`
+	State       string      `json:"state"`
+	Info        interface{} `json:"info"`
+	ErrorCount  int         `json:"error_count"`
+	StartTime   string      `json:"start_time"`
+	LastUpdated string      `json:"last_updated"`
+}
+
+// SchedulerJob is a running replication as listed by _scheduler/jobs
+type SchedulerJob struct {
+	Database  string `json:"database"`
+	DocID     string `json:"doc_id"`
+	ID        string `json:"id"`
+	Node      string `json:"node"`
+	Source    string `json:"source"`
+	Target    string `json:"target"`
+	StartTime string `json:"start_time"`
+	History   []struct {
+		Timestamp string `json:"timestamp"`
+		Type      string `json:"type"`
+		Reason    string `json:"reason"`
+	} `json:"history"`
+}
+
+// Replicate creates a replication (a document in _replicator)
+func Replicate(c *Clippan, args []string) error {
+	var continuous, createTarget bool
+	var id, filter, selector, docIDs string
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: replicate [flags] <source> <target>\n")
+		fmt.Fprintf(os.Stderr, "source and target are database names on the current server or full dsns\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&continuous, "continuous", false, "Keep replicating new changes")
+	fs.BoolVar(&createTarget, "create-target", false, "Create the target database if it doesn't exist")
+	fs.StringVar(&id, "id", "", "Id of the replication document (generated if empty)")
+	fs.StringVar(&filter, "filter", "", "Filter function to use (design-doc/filter)")
+	fs.StringVar(&selector, "selector", "", "Only replicate documents matching this (JSON) selector")
+	fs.StringVar(&docIDs, "doc-ids", "", "Only replicate these (comma separated) document ids")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(positional) != 2 {
+		fs.Usage()
+		return UsageError
+	}
+	if countSet(filter != "", selector != "", docIDs != "") > 1 {
+		return errors.New("-filter, -selector and -doc-ids can't be combined")
+	}
+
+	source, err := c.ResolveDSN(positional[0])
+	if err != nil {
+		return err
+	}
+	target, err := c.ResolveDSN(positional[1])
+	if err != nil {
+		return err
+	}
+	doc := map[string]interface{}{
+		"source": source,
+		"target": target,
+	}
+	if continuous {
+		doc["continuous"] = true
+	}
+	if createTarget {
+		doc["create_target"] = true
+	}
+	if filter != "" {
+		doc["filter"] = filter
+	}
+	if selector != "" {
+		var sel map[string]interface{}
+		if err := json.Unmarshal([]byte(selector), &sel); err != nil {
+			return fmt.Errorf("invalid selector: %s", err)
+		}
+		doc["selector"] = sel
+	}
+	if docIDs != "" {
+		doc["doc_ids"] = strings.Split(docIDs, ",")
+	}
+
+	db := c.client.DB(context.TODO(), replicatorDB)
+	if id == "" {
+		id, _, err = db.CreateDoc(context.TODO(), doc)
+	} else {
+		_, err = db.Put(context.TODO(), id, doc)
+	}
+	if err != nil {
+		return err
+	}
+	c.Print("Replication %s created: %s -> %s", id, redactDSN(source), redactDSN(target))
+	return nil
+}
+
+// countSet counts the conditions that are true
+func countSet(conditions ...bool) int {
+	count := 0
+	for _, c := range conditions {
+		if c {
+			count++
+		}
+	}
+	return count
+}
+
+// Replications lists the replication documents and jobs with their state
+func Replications(c *Clippan, args []string) error {
+	var useJson bool
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.BoolVar(&useJson, "json", false, "Output json")
+	if fs.Parse(args[1:]) != nil {
+		return nil // help will have been printed
+	}
+
+	var docs struct {
+		Docs []*SchedulerDoc `json:"docs"`
+	}
+	if err := c.Get("/_scheduler/docs", &docs); err != nil {
+		return err
+	}
+	var jobs struct {
+		Jobs []*SchedulerJob `json:"jobs"`
+	}
+	if err := c.Get("/_scheduler/jobs", &jobs); err != nil {
+		return err
+	}
+	if useJson {
+		c.JSON(MustMarshal(map[string]interface{}{"docs": docs.Docs, "jobs": jobs.Jobs}))
+		return nil
+	}
+
+	c.Print("Replication documents:")
+	c.Print("%-32s %-12s %s", "Id", "State", "Source -> Target")
+	for _, d := range docs.Docs {
+		c.Print("%-32s %-12s %s -> %s", d.DocID, d.State, redactDSN(d.Source), redactDSN(d.Target))
+		if d.ErrorCount > 0 || d.State == "crashing" || d.State == "failed" {
+			c.Print("%32s errors: %d, %v", "", d.ErrorCount, d.Info)
+		}
+	}
+	c.Print("")
+	c.Print("Jobs:")
+	c.Print("%-32s %-32s %s", "Id", "Document", "Last event")
+	for _, j := range jobs.Jobs {
+		docID := j.DocID
+		if docID == "" {
+			docID = "(transient)"
+		}
+		event := ""
+		if len(j.History) > 0 {
+			// history is most recent first
+			h := j.History[0]
+			event = h.Timestamp + " " + h.Type
+			if h.Reason != "" {
+				event += ": " + h.Reason
+			}
+		}
+		c.Print("%-32s %-32s %s", j.ID, docID, event)
+	}
+	return nil
+}
+
+// CancelReplication cancels a replication, either by removing its document
+// from _replicator or, for transient replications, by its job id
+func CancelReplication(c *Clippan, args []string) error {
+	if len(args) != 2 {
+		return UsageError
+	}
+	id := args[1]
+
+	db := c.client.DB(context.TODO(), replicatorDB)
+	var doc map[string]interface{}
+	found, err := helpers.GetOr404(db, id, &doc)
+	if err != nil {
+		return err
+	}
+	if found {
+		if _, err := db.Delete(context.TODO(), id, doc["_rev"].(string)); err != nil {
+			return err
+		}
+		c.Print("Replication document %s deleted", id)
+		return nil
+	}
+
+	err = c.Request(http.MethodPost, "/_replicate", map[string]interface{}{
+		"replication_id": id,
+		"cancel":         true,
+	}, nil)
+	if StatusCode(err) == http.StatusNotFound {
+		return ReplicationNotFoundError
+	} else if err != nil {
+		return err
+	}
+	c.Print("Replication %s cancelled", id)
+	return nil
+}
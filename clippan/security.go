@@ -0,0 +1,193 @@
+package clippan
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-kivik/kivik/v4"
+)
+
+var SecurityGroupError = errors.New("Group must be admins or members")
+
+// securityPath returns the path of the security object of a database
+func securityPath(db string) string {
+	return "/" + url.PathEscape(db) + "/_security"
+}
+
+// securityNames returns the names or roles of the admins or members in a raw
+// security object
+func securityNames(sec map[string]interface{}, group, key string) []string {
+	g, _ := sec[group].(map[string]interface{})
+	list, _ := g[key].([]interface{})
+	var names []string
+	for _, name := range list {
+		if s, ok := name.(string); ok {
+			names = append(names, s)
+		}
+	}
+	return names
+}
+
+// setSecurityNames sets the names or roles of the admins or members in a raw
+// security object, leaving anything else in it as it is
+func setSecurityNames(sec map[string]interface{}, group, key string, names []string) {
+	g, ok := sec[group].(map[string]interface{})
+	if !ok {
+		g = map[string]interface{}{}
+		sec[group] = g
+	}
+	if names == nil {
+		names = []string{}
+	}
+	g[key] = names
+}
+
+// toSecurity converts a raw security object for printing
+func toSecurity(sec map[string]interface{}) *kivik.Security {
+	res := &kivik.Security{}
+	MustUnmarshal(MustMarshal(sec), res)
+	return res
+}
+
+func formatNames(names []string) string {
+	if len(names) == 0 {
+		return "-"
+	}
+	return strings.Join(names, ", ")
+}
+
+func printSecurity(c *Clippan, sec *kivik.Security) {
+	c.Print("admins:  names: %s; roles: %s", formatNames(sec.Admins.Names), formatNames(sec.Admins.Roles))
+	c.Print("members: names: %s; roles: %s", formatNames(sec.Members.Names), formatNames(sec.Members.Roles))
+}
+
+// addNames adds the names not in list yet, it returns the new list and if it changed
+func addNames(list []string, names []string) ([]string, bool) {
+	changed := false
+	for _, name := range names {
+		if !contains(list, name) {
+			list = append(list, name)
+			changed = true
+		}
+	}
+	return list, changed
+}
+
+// removeNames removes names from list, it returns the new list and if it changed
+func removeNames(list []string, names []string) ([]string, bool) {
+	var res []string
+	for _, name := range list {
+		if !contains(names, name) {
+			res = append(res, name)
+		}
+	}
+	return res, len(res) != len(list)
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Security shows the security object of the current database
+func Security(c *Clippan, args []string) error {
+	var useJson bool
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.BoolVar(&useJson, "json", false, "Output json")
+	if fs.Parse(args[1:]) != nil {
+		return nil // help will have been printed
+	}
+
+	sec, err := c.database.Security(context.TODO())
+	if err != nil {
+		return err
+	}
+	if useJson {
+		c.JSON(MustMarshal(sec))
+	} else {
+		printSecurity(c, sec)
+	}
+	return nil
+}
+
+// Grant adds users or roles to the admins or members of the current database
+func Grant(c *Clippan, args []string) error {
+	return changeSecurity(c, args, addNames)
+}
+
+// Revoke removes users or roles from the admins or members of the current database
+func Revoke(c *Clippan, args []string) error {
+	return changeSecurity(c, args, removeNames)
+}
+
+func changeSecurity(c *Clippan, args []string, change func([]string, []string) ([]string, bool)) error {
+	var roles bool
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] admins|members <name>...\n", args[0])
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&roles, "role", false, "The names are roles in stead of users")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(positional) < 2 {
+		fs.Usage()
+		return UsageError
+	}
+
+	group := positional[0]
+	if group != "admins" && group != "members" {
+		return SecurityGroupError
+	}
+	key := "names"
+	if roles {
+		key = "roles"
+	}
+
+	// the raw object is changed, so other fields (set by other tools) are kept
+	path := securityPath(c.database.Name())
+	var raw map[string]interface{}
+	if err := c.Get(path, &raw); err != nil {
+		return err
+	}
+	sec := toSecurity(raw)
+	names, changed := change(securityNames(raw, group, key), positional[1:])
+	if !changed {
+		c.Print("Nothing to change")
+		printSecurity(c, sec)
+		return nil
+	}
+	setSecurityNames(raw, group, key, names)
+	updated := toSecurity(raw)
+
+	hadMembers := len(sec.Members.Names) > 0 || len(sec.Members.Roles) > 0
+	if hadMembers && len(updated.Members.Names) == 0 && len(updated.Members.Roles) == 0 {
+		in := c.Prompt.Input("This leaves the database without members, which makes it public. Continue? (y/N)> ")
+		if strings.ToLower(in) != "y" {
+			return nil
+		}
+	}
+
+	if err := c.Request(http.MethodPut, path, raw, nil); err != nil {
+		return err
+	}
+	c.Print("Before:")
+	printSecurity(c, sec)
+	c.Print("After:")
+	printSecurity(c, updated)
+	return nil
+}
@@ -0,0 +1,79 @@
+package clippan
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurity(t *testing.T) {
+	DB := helpers.DBSession("test-security")
+
+	t.Run("Test grant in ro mode", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("grant members bob")
+		assert.Len(t, printer.Errors, 1)
+	}))
+	t.Run("Test grant and revoke", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, true, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("grant members bob alice")
+		c.Executer("grant -role admins ops")
+		assert.Len(printer.Errors, 0)
+
+		sec, err := cdb.DB().Security(context.TODO())
+		assert.NoError(err)
+		assert.Equal([]string{"bob", "alice"}, sec.Members.Names)
+		assert.Equal([]string{"ops"}, sec.Admins.Roles)
+
+		c.Executer("revoke members bob")
+		c.Executer("grant owners bob")
+		assert.Len(printer.Errors, 1)
+		sec, err = cdb.DB().Security(context.TODO())
+		assert.NoError(err)
+		assert.Equal([]string{"alice"}, sec.Members.Names)
+
+		printer.Prints = nil
+		c.Executer("security")
+		assert.Equal("members: names: alice; roles: -\n", printer.Prints[1])
+	}))
+	t.Run("Test revoke keeps other fields and asks before making the db public", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		prompt := NewMockPrompt().SetMockData("n")
+		c := NewTestClippan(cdb, true, printer, NewMockEditor(), prompt)
+
+		c.Executer("use " + cdb.DB().Name())
+		path := securityPath(cdb.DB().Name())
+		assert.NoError(c.Request(http.MethodPut, path, map[string]interface{}{
+			"admins":   map[string]interface{}{"names": []string{"root"}},
+			"members":  map[string]interface{}{"names": []string{"bob"}},
+			"cloudant": map[string]interface{}{"nobody": []string{"_reader"}},
+		}, nil))
+
+		c.Executer("revoke members bob")
+		assert.Len(prompt.Inputs, 1)
+		sec, err := cdb.DB().Security(context.TODO())
+		assert.NoError(err)
+		assert.Equal([]string{"bob"}, sec.Members.Names)
+
+		prompt.SetMockData("y")
+		c.Executer("revoke members bob")
+		c.Executer("grant admins ops")
+		assert.Len(printer.Errors, 0)
+		var raw map[string]interface{}
+		assert.NoError(c.Get(path, &raw))
+		assert.Equal(map[string]interface{}{"nobody": []interface{}{"_reader"}}, raw["cloudant"])
+		assert.Equal([]interface{}{"root", "ops"}, raw["admins"].(map[string]interface{})["names"])
+		assert.Equal([]interface{}{}, raw["members"].(map[string]interface{})["names"])
+	}))
+}
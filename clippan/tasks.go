@@ -0,0 +1,175 @@
+package clippan
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ActiveTask is a task as listed by _active_tasks
+type ActiveTask struct {
+	Type           string `json:"type"`
+	Node           string `json:"node"`
+	PID            string `json:"pid"`
+	Database       string `json:"database"`
+	Source         string `json:"source"`
+	Target         string `json:"target"`
+	DesignDocument string `json:"design_document"`
+	Phase          string `json:"phase"`
+	Progress       int    `json:"progress"`
+	ChangesDone    int    `json:"changes_done"`
+	TotalChanges   int    `json:"total_changes"`
+	StartedOn      int64  `json:"started_on"`
+	UpdatedOn      int64  `json:"updated_on"`
+}
+
+// shardPattern matches the shard files in cluster task database names,
+// e.g. shards/00000000-7fffffff/mydb.1600000000
+var shardPattern = regexp.MustCompile(`^shards/[0-9a-f]+-[0-9a-f]+/(.*)\.[0-9]+$`)
+
+// DatabaseName returns the name of the database a task runs on, without
+// the shard it runs on
+func (t *ActiveTask) DatabaseName() string {
+	if m := shardPattern.FindStringSubmatch(t.Database); m != nil {
+		return m[1]
+	}
+	return t.Database
+}
+
+// ActiveTasks gets the active tasks on the server
+func ActiveTasks(c *Clippan) ([]*ActiveTask, error) {
+	var tasks []*ActiveTask
+	if err := c.Get("/_active_tasks", &tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// ProgressBar renders a progress bar of width characters
+func ProgressBar(done, total, width int) string {
+	if total <= 0 {
+		total, done = 1, 0
+	}
+	if done > total {
+		done = total
+	}
+	filled := done * width / total
+	return fmt.Sprintf("[%s%s] %3d%% (%d/%d)",
+		strings.Repeat("#", filled), strings.Repeat(".", width-filled),
+		done*100/total, done, total)
+}
+
+// watch calls f every interval until it returns true or an error, or until
+// interrupted, in which case it returns false
+func watch(interval time.Duration, f func() (bool, error)) (bool, error) {
+	// the prompt doesn't handle signals while a command runs
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if done, err := f(); done || err != nil {
+			return done, err
+		}
+		select {
+		case <-interrupt:
+			return false, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// taskProgress describes the progress of a task, if known
+func taskProgress(t *ActiveTask) string {
+	if t.TotalChanges > 0 {
+		return fmt.Sprintf("%d%% (%d/%d)", t.ChangesDone*100/t.TotalChanges, t.ChangesDone, t.TotalChanges)
+	}
+	if t.Progress > 0 {
+		return fmt.Sprintf("%d%%", t.Progress)
+	}
+	return "-"
+}
+
+// filterTasks returns the tasks whose type contains any of types, or all
+// if no types are given. E.g. "compaction" matches both database and view compaction
+func filterTasks(tasks []*ActiveTask, types []string) []*ActiveTask {
+	if len(types) == 0 {
+		return tasks
+	}
+	var res []*ActiveTask
+	for _, t := range tasks {
+		for _, typ := range types {
+			if strings.Contains(t.Type, typ) {
+				res = append(res, t)
+				break
+			}
+		}
+	}
+	return res
+}
+
+func printTasks(c *Clippan, tasks []*ActiveTask) {
+	c.Print("%-20s %-30s %-20s %-18s %-19s %s", "Type", "Database", "Design doc", "Progress", "Started", "Node")
+	for _, t := range tasks {
+		database := t.DatabaseName()
+		if database == "" && t.Source != "" {
+			database = redactDSN(t.Source) + " -> " + redactDSN(t.Target)
+		}
+		started := "-"
+		if t.StartedOn > 0 {
+			started = time.Unix(t.StartedOn, 0).Format("2006-01-02 15:04:05")
+		}
+		c.Print("%-20s %-30s %-20s %-18s %-19s %s", t.Type, database, t.DesignDocument, taskProgress(t), started, t.Node)
+	}
+	if len(tasks) == 0 {
+		c.Print("No active tasks")
+	}
+}
+
+// Tasks lists the active tasks on the server, optionally refreshing them
+func Tasks(c *Clippan, args []string) error {
+	var useJson bool
+	var interval int
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: tasks [flags] [type...]\n")
+		fmt.Fprintf(os.Stderr, "Types are e.g. indexer, replication or compaction\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&useJson, "json", false, "Output json")
+	fs.IntVar(&interval, "watch", 0, "Refresh every N seconds until interrupted")
+	types, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+
+	show := func() (bool, error) {
+		tasks, err := ActiveTasks(c)
+		if err != nil {
+			return false, err
+		}
+		tasks = filterTasks(tasks, types)
+		if useJson {
+			c.JSON(MustMarshal(tasks))
+		} else {
+			if interval > 0 {
+				c.Print("-- %s", time.Now().Format("15:04:05"))
+			}
+			printTasks(c, tasks)
+		}
+		return interval <= 0, nil
+	}
+	if interval <= 0 {
+		_, err := show()
+		return err
+	}
+	_, err = watch(time.Duration(interval)*time.Second, show)
+	return err
+}
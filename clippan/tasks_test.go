@@ -0,0 +1,66 @@
+package clippan
+
+import (
+	"testing"
+
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskHelpers(t *testing.T) {
+	t.Run("Test ProgressBar", func(t *testing.T) {
+		assert := assert.New(t)
+		assert.Equal("[#####.....]  50% (5/10)", ProgressBar(5, 10, 10))
+		assert.Equal("[..........]   0% (0/1)", ProgressBar(0, 0, 10))
+		assert.Equal("[##########] 100% (12/12)", ProgressBar(15, 12, 10))
+	})
+	t.Run("Test FormatSize", func(t *testing.T) {
+		assert := assert.New(t)
+		assert.Equal("512 B", FormatSize(512))
+		assert.Equal("1.5 KiB", FormatSize(1536))
+		assert.Equal("2.0 GiB", FormatSize(2<<30))
+	})
+	t.Run("Test indexer progress", func(t *testing.T) {
+		assert := assert.New(t)
+		tasks := []*ActiveTask{
+			{Type: "indexer", Database: "shards/00000000-7fffffff/db.1600000000", DesignDocument: "_design/a", ChangesDone: 5, TotalChanges: 10},
+			{Type: "indexer", Database: "shards/80000000-ffffffff/db.1600000000", DesignDocument: "_design/a", ChangesDone: 10, TotalChanges: 10},
+			{Type: "indexer", Database: "shards/80000000-ffffffff/other.1600000000", DesignDocument: "_design/a", ChangesDone: 1, TotalChanges: 10},
+			{Type: "view_compaction", Database: "db", DesignDocument: "_design/a"},
+		}
+		done, total, found := indexerProgress(tasks, "db", "_design/a")
+		assert.True(found)
+		assert.Equal(15, done)
+		assert.Equal(20, total)
+
+		_, _, found = indexerProgress(tasks, "db", "_design/b")
+		assert.False(found)
+	})
+	t.Run("Test filterTasks", func(t *testing.T) {
+		assert := assert.New(t)
+		tasks := []*ActiveTask{{Type: "indexer"}, {Type: "database_compaction"}, {Type: "view_compaction"}, {Type: "replication"}}
+		assert.Len(filterTasks(tasks, nil), 4)
+		assert.Len(filterTasks(tasks, []string{"compaction"}), 2)
+		assert.Len(filterTasks(tasks, []string{"indexer", "replication"}), 2)
+	})
+	t.Run("Test taskProgress", func(t *testing.T) {
+		assert := assert.New(t)
+		assert.Equal("25% (1/4)", taskProgress(&ActiveTask{ChangesDone: 1, TotalChanges: 4}))
+		assert.Equal("30%", taskProgress(&ActiveTask{Progress: 30}))
+		assert.Equal("-", taskProgress(&ActiveTask{}))
+	})
+}
+
+func TestTasks(t *testing.T) {
+	DB := helpers.DBSession("test-tasks")
+
+	t.Run("Test tasks", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("tasks indexer")
+		assert.Len(printer.Errors, 0)
+		assert.Contains(printer.Prints[0], "Progress")
+	}))
+}
@@ -0,0 +1,59 @@
+//go:build !windows
+// +build !windows
+
+package clippan
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/term/termios"
+)
+
+type winsize struct {
+	Row    uint16
+	Col    uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+// terminalHeight returns the height of the terminal attached to stdout,
+// or 0 if it can't be determined (e.g. stdout is not a terminal)
+func terminalHeight() int {
+	ws := &winsize{}
+	retCode, _, _ := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		os.Stdout.Fd(),
+		uintptr(syscall.TIOCGWINSZ),
+		uintptr(unsafe.Pointer(ws)))
+
+	if int(retCode) == -1 {
+		return 0
+	}
+	return int(ws.Row)
+}
+
+// readPassword reads a line from stdin without echoing it
+func readPassword(prompt string) (string, error) {
+	fd := os.Stdin.Fd()
+	var old syscall.Termios
+	if err := termios.Tcgetattr(fd, &old); err != nil {
+		return "", err
+	}
+	noEcho := old
+	noEcho.Lflag &^= syscall.ECHO
+	noEcho.Lflag |= syscall.ICANON
+	if err := termios.Tcsetattr(fd, termios.TCSANOW, &noEcho); err != nil {
+		return "", err
+	}
+	defer termios.Tcsetattr(fd, termios.TCSANOW, &old) // nolint: errcheck
+
+	fmt.Print(prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	fmt.Println()
+	return strings.TrimRight(line, "\r\n"), err
+}
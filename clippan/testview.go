@@ -0,0 +1,469 @@
+package clippan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/robertkrimen/otto"
+)
+
+/*
+ * Local map/reduce, to test view functions against a sample of documents
+ * without saving them to the server (which would trigger an index build)
+ */
+
+// viewRuntime is javascript that makes the functions behave like they
+// would in CouchDB. Values are passed as JSON to keep conversions exact
+const viewRuntime = `
+var __rows = [];
+function emit(key, value) {
+  __rows.push([key === undefined ? null : key, value === undefined ? null : value]);
+}
+function log(message) {
+  __log(typeof message === "string" ? message : JSON.stringify(message));
+}
+function sum(values) {
+  var s = 0;
+  for (var i = 0; i < values.length; i++) {
+    s += values[i];
+  }
+  return s;
+}
+function isArray(obj) {
+  return Array.isArray(obj);
+}
+function toJSON(obj) {
+  return JSON.stringify(obj);
+}
+function __runMap(doc) {
+  __rows = [];
+  __map(JSON.parse(doc));
+  return JSON.stringify(__rows);
+}
+function __runReduce(keys, values, rereduce) {
+  var result = __reduce(JSON.parse(keys), JSON.parse(values), rereduce);
+  return JSON.stringify(result === undefined ? null : result);
+}
+`
+
+// viewTimeout is the maximum time a single map or reduce call may take
+const viewTimeout = 5 * time.Second
+
+var errViewTimeout = errors.New("function took too long")
+
+// ViewRunner runs map and reduce functions locally
+type ViewRunner struct {
+	vm     *otto.Otto
+	reduce string
+}
+
+// NewViewRunner compiles the map and (optional) reduce function. Log
+// messages from the functions are passed to logf
+func NewViewRunner(mapFn, reduceFn string, logf func(string)) (*ViewRunner, error) {
+	vm := otto.New()
+	if err := vm.Set("__log", func(call otto.FunctionCall) otto.Value {
+		logf(call.Argument(0).String())
+		return otto.UndefinedValue()
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := vm.Run(viewRuntime); err != nil {
+		return nil, err
+	}
+	if _, err := vm.Run("var __map = (" + mapFn + ");"); err != nil {
+		return nil, fmt.Errorf("map: %s", err)
+	}
+	reduceFn = strings.TrimSpace(reduceFn)
+	if reduceFn != "" && !strings.HasPrefix(reduceFn, "_") {
+		if _, err := vm.Run("var __reduce = (" + reduceFn + ");"); err != nil {
+			return nil, fmt.Errorf("reduce: %s", err)
+		}
+	}
+	return &ViewRunner{vm: vm, reduce: reduceFn}, nil
+}
+
+// call calls a javascript function that returns JSON, and decodes the result
+func (r *ViewRunner) call(result interface{}, name string, args ...interface{}) (err error) {
+	r.vm.Interrupt = make(chan func(), 1)
+	timer := time.AfterFunc(viewTimeout, func() {
+		r.vm.Interrupt <- func() {
+			panic(errViewTimeout)
+		}
+	})
+	defer func() {
+		timer.Stop()
+		if caught := recover(); caught != nil {
+			if caught != errViewTimeout {
+				panic(caught)
+			}
+			err = errViewTimeout
+		}
+	}()
+
+	value, err := r.vm.Call(name, nil, args...)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(value.String()), result)
+}
+
+// Map runs the map function on a document, returning the emitted key/value pairs
+func (r *ViewRunner) Map(doc []byte) ([][2]interface{}, error) {
+	var rows [][2]interface{}
+	if err := r.call(&rows, "__runMap", string(doc)); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// HasReduce tells if there's a reduce function
+func (r *ViewRunner) HasReduce() bool {
+	return r.reduce != ""
+}
+
+// Reduce reduces rows to a single value, using either a builtin or the
+// javascript reduce function
+func (r *ViewRunner) Reduce(rows []*QueryResult) (interface{}, error) {
+	values := make([]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = row.Value
+	}
+	switch r.reduce {
+	case "_count":
+		return len(rows), nil
+	case "_sum":
+		return reduceSum(values)
+	case "_stats":
+		return reduceStats(values)
+	}
+	if strings.HasPrefix(r.reduce, "_") {
+		return nil, fmt.Errorf("unsupported builtin reduce %s", r.reduce)
+	}
+	keys := make([][2]interface{}, len(rows))
+	for i, row := range rows {
+		keys[i] = [2]interface{}{row.Key, row.ID}
+	}
+	var result interface{}
+	if err := r.call(&result, "__runReduce", string(MustMarshal(keys)), string(MustMarshal(values)), false); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func toNumbers(values []interface{}) ([]float64, error) {
+	numbers := make([]float64, len(values))
+	for i, v := range values {
+		n, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("value %v is not a number", v)
+		}
+		numbers[i] = n
+	}
+	return numbers, nil
+}
+
+func reduceSum(values []interface{}) (interface{}, error) {
+	numbers, err := toNumbers(values)
+	if err != nil {
+		return nil, err
+	}
+	total := 0.0
+	for _, n := range numbers {
+		total += n
+	}
+	return total, nil
+}
+
+func reduceStats(values []interface{}) (interface{}, error) {
+	numbers, err := toNumbers(values)
+	if err != nil {
+		return nil, err
+	}
+	stats := map[string]float64{"sum": 0, "count": float64(len(numbers)), "min": 0, "max": 0, "sumsqr": 0}
+	for i, n := range numbers {
+		stats["sum"] += n
+		stats["sumsqr"] += n * n
+		if i == 0 || n < stats["min"] {
+			stats["min"] = n
+		}
+		if i == 0 || n > stats["max"] {
+			stats["max"] = n
+		}
+	}
+	return stats, nil
+}
+
+// collationRank orders JSON types the way CouchDB does
+func collationRank(v interface{}) int {
+	switch t := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		if !t {
+			return 1
+		}
+		return 2
+	case float64:
+		return 3
+	case string:
+		return 4
+	case []interface{}:
+		return 5
+	}
+	return 6
+}
+
+// CollateJSON compares two (decoded) JSON values using (an approximation of)
+// CouchDB's view collation. Strings are compared bytewise, not using ICU
+func CollateJSON(a, b interface{}) int {
+	ra, rb := collationRank(a), collationRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+	switch ta := a.(type) {
+	case float64:
+		tb := b.(float64)
+		if ta < tb {
+			return -1
+		} else if ta > tb {
+			return 1
+		}
+	case string:
+		return strings.Compare(ta, b.(string))
+	case []interface{}:
+		tb := b.([]interface{})
+		for i := 0; i < len(ta) && i < len(tb); i++ {
+			if c := CollateJSON(ta[i], tb[i]); c != 0 {
+				return c
+			}
+		}
+		return len(ta) - len(tb)
+	case map[string]interface{}:
+		// compare as sorted lists of key/value pairs
+		tb := b.(map[string]interface{})
+		ka, kb := sortedKeys(ta), sortedKeys(tb)
+		for i := 0; i < len(ka) && i < len(kb); i++ {
+			if c := strings.Compare(ka[i], kb[i]); c != 0 {
+				return c
+			}
+			if c := CollateJSON(ta[ka[i]], tb[kb[i]]); c != 0 {
+				return c
+			}
+		}
+		return len(ka) - len(kb)
+	}
+	return 0
+}
+
+// SortRows sorts view rows by key, then by document id
+func SortRows(rows []*QueryResult) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		if c := CollateJSON(rows[i].Key, rows[j].Key); c != 0 {
+			return c < 0
+		}
+		return rows[i].ID < rows[j].ID
+	})
+}
+
+// sampleDocs fetches the documents to test against, either by id or the first
+// `sample` non-design documents from _all_docs
+func sampleDocs(c *Clippan, ids []string, sample int) (map[string][]byte, []string, error) {
+	docs := map[string][]byte{}
+	if len(ids) > 0 {
+		for _, id := range ids {
+			var doc json.RawMessage
+			found, err := helpers.GetOr404(c.database, id, &doc)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !found {
+				c.Error("%s: %s", id, DocumentNotFoundError)
+				continue
+			}
+			docs[id] = doc
+		}
+		return docs, ids, nil
+	}
+
+	rows, err := c.database.AllDocs(context.TODO(), kivik.Options{
+		"include_docs": true,
+		"limit":        sample,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if strings.HasPrefix(rows.ID(), "_design/") {
+			continue
+		}
+		var doc json.RawMessage
+		if err := rows.ScanDoc(&doc); err != nil {
+			return nil, nil, err
+		}
+		docs[rows.ID()] = doc
+		ids = append(ids, rows.ID())
+	}
+	return docs, ids, rows.Err()
+}
+
+// viewSource returns the map and reduce functions to test, from a file,
+// an existing view or edited from a template
+func viewSource(c *Clippan, file, from string) (string, string, error) {
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", "", err
+		}
+		if !bytes.Contains(data, []byte(sectionPrefix)) {
+			// just a map function
+			return string(data), "", nil
+		}
+		_, _, sections, err := splitSections(data)
+		return sections["map"], sections["reduce"], err
+	}
+
+	view := helpers.View{Map: mapTemplate}
+	if from != "" {
+		parts := strings.SplitN(from, "/", 2)
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("-from expects design-doc/view")
+		}
+		dd := &helpers.DesignDoc{}
+		found, err := helpers.GetOr404(c.database, DesignDocID(parts[0]), dd)
+		if err != nil {
+			return "", "", err
+		}
+		var exists bool
+		if view, exists = dd.Views[parts[1]]; !found || !exists {
+			return "", "", fmt.Errorf("view %s not found", from)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("// Map (and optional reduce) function to test. Nothing is saved to the server\n")
+	buf.WriteString(sectionPrefix + "map\n" + view.Map + "\n")
+	if view.Reduce != "" {
+		buf.WriteString(sectionPrefix + "reduce\n" + view.Reduce + "\n")
+	}
+	data, err := c.Editor.Edit(buf.Bytes())
+	if err != nil {
+		return "", "", err
+	}
+	_, _, sections, err := splitSections(data)
+	return sections["map"], sections["reduce"], err
+}
+
+// TestView runs a map (and reduce) function locally against a sample of documents
+func TestView(c *Clippan, args []string) error {
+	var useJson, reduce, group bool
+	var sample int
+	var file, from string
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: testview [flags] [id...]\n")
+		fmt.Fprintf(os.Stderr, "Runs a map (and reduce) function locally against the given documents or a sample of them\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&useJson, "json", false, "Output json")
+	fs.BoolVar(&reduce, "reduce", false, "Reduce the results")
+	fs.BoolVar(&group, "group", false, "Group reduce results by key (implies -reduce)")
+	fs.IntVar(&sample, "sample", 20, "Amount of documents to test against, if no ids are given")
+	fs.StringVar(&file, "file", "", "Read the functions from a file in stead of editing them")
+	fs.StringVar(&from, "from", "", "Start with the functions of an existing view (design-doc/view)")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if group {
+		reduce = true
+	}
+
+	mapFn, reduceFn, err := viewSource(c, file, from)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(mapFn) == "" {
+		return errors.New("no map function")
+	}
+	runner, err := NewViewRunner(mapFn, reduceFn, func(msg string) {
+		c.Print("log: %s", msg)
+	})
+	if err != nil {
+		return err
+	}
+	if reduce && !runner.HasReduce() {
+		return errors.New("no reduce function to reduce with")
+	}
+
+	docs, ids, err := sampleDocs(c, positional, sample)
+	if err != nil {
+		return err
+	}
+
+	var result []*QueryResult
+	for _, id := range ids {
+		doc, ok := docs[id]
+		if !ok {
+			continue
+		}
+		rows, err := runner.Map(doc)
+		if err != nil {
+			// CouchDB skips documents the map function fails on
+			c.Error("map failed on %s: %s", id, err)
+			continue
+		}
+		for _, row := range rows {
+			result = append(result, &QueryResult{ID: id, Key: row[0], Value: row[1]})
+		}
+	}
+	SortRows(result)
+
+	if reduce {
+		if result, err = reduceRows(runner, result, group); err != nil {
+			return err
+		}
+	}
+	if !useJson {
+		c.Print("Tested against %d documents", len(docs))
+	}
+	printQueryResults(c, result, useJson)
+	return nil
+}
+
+// reduceRows reduces sorted rows to a single row or, when grouping, a row per key
+func reduceRows(runner *ViewRunner, rows []*QueryResult, group bool) ([]*QueryResult, error) {
+	if !group {
+		value, err := runner.Reduce(rows)
+		if err != nil {
+			return nil, err
+		}
+		return []*QueryResult{{Value: value}}, nil
+	}
+	var result []*QueryResult
+	for start := 0; start < len(rows); {
+		end := start + 1
+		for end < len(rows) && CollateJSON(rows[start].Key, rows[end].Key) == 0 {
+			end++
+		}
+		value, err := runner.Reduce(rows[start:end])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &QueryResult{Key: rows[start].Key, Value: value})
+		start = end
+	}
+	return result, nil
+}
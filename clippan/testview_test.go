@@ -0,0 +1,78 @@
+package clippan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViewRunner(t *testing.T) {
+	t.Run("Test map", func(t *testing.T) {
+		assert := assert.New(t)
+		var logs []string
+		r, err := NewViewRunner(`function (doc) { log(doc.tags); doc.tags.forEach(function (t) { emit(t); }); }`, "", func(m string) {
+			logs = append(logs, m)
+		})
+		assert.NoError(err)
+		assert.False(r.HasReduce())
+
+		rows, err := r.Map([]byte(`{"_id": "a", "tags": ["x", "y"]}`))
+		assert.NoError(err)
+		assert.Equal([][2]interface{}{{"x", nil}, {"y", nil}}, rows)
+		assert.Equal([]string{`["x","y"]`}, logs)
+
+		_, err = r.Map([]byte(`{"_id": "b"}`))
+		assert.Error(err)
+	})
+	t.Run("Test syntax error", func(t *testing.T) {
+		_, err := NewViewRunner(`function (doc) {`, "", func(string) {})
+		assert.Error(t, err)
+	})
+	t.Run("Test reduce", func(t *testing.T) {
+		assert := assert.New(t)
+		rows := []*QueryResult{
+			{ID: "a", Key: "x", Value: 1.0},
+			{ID: "b", Key: "x", Value: 2.0},
+			{ID: "c", Key: "y", Value: 4.0},
+		}
+		for reduce, expected := range map[string]interface{}{
+			"_count": []interface{}{2, 1},
+			"_sum":   []interface{}{3.0, 4.0},
+			"function (keys, values) { return values.length * 10; }": []interface{}{20.0, 10.0},
+		} {
+			r, err := NewViewRunner(`function (doc) {}`, reduce, func(string) {})
+			assert.NoError(err)
+			assert.True(r.HasReduce())
+
+			grouped, err := reduceRows(r, rows, true)
+			assert.NoError(err)
+			assert.Len(grouped, 2)
+			assert.Equal(expected, []interface{}{grouped[0].Value, grouped[1].Value}, reduce)
+		}
+
+		r, _ := NewViewRunner(`function (doc) {}`, "_stats", func(string) {})
+		total, err := reduceRows(r, rows, false)
+		assert.NoError(err)
+		assert.Equal(map[string]float64{"sum": 7, "count": 3, "min": 1, "max": 4, "sumsqr": 21}, total[0].Value)
+	})
+}
+
+func TestCollateJSON(t *testing.T) {
+	assert := assert.New(t)
+	ordered := []interface{}{
+		nil, false, true, 1.0, 2.0, "a", "b",
+		[]interface{}{"a"}, []interface{}{"a", 1.0}, []interface{}{"b"},
+		map[string]interface{}{"a": 1.0},
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		assert.True(CollateJSON(ordered[i], ordered[i+1]) < 0, "%v < %v", ordered[i], ordered[i+1])
+		assert.True(CollateJSON(ordered[i+1], ordered[i]) > 0, "%v > %v", ordered[i+1], ordered[i])
+		assert.Equal(0, CollateJSON(ordered[i], ordered[i]))
+	}
+
+	rows := []*QueryResult{{ID: "b", Key: 1.0}, {ID: "c", Key: nil}, {ID: "a", Key: 1.0}}
+	SortRows(rows)
+	assert.Equal("c", rows[0].ID)
+	assert.Equal("a", rows[1].ID)
+	assert.Equal("b", rows[2].ID)
+}
@@ -0,0 +1,273 @@
+package clippan
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/iivvoo/clippan/helpers"
+)
+
+const (
+	usersDB      = "_users"
+	userIDPrefix = "org.couchdb.user:"
+)
+
+var UserNotFoundError = errors.New("User not found")
+var UserExistsError = errors.New("User already exists")
+var PasswordMismatchError = errors.New("Passwords don't match")
+var EmptyPasswordError = errors.New("Password can't be empty")
+
+// passwordFields are the fields of a user document that contain (derived) password information
+var passwordFields = []string{"password", "password_scheme", "pbkdf2_prf", "derived_key", "salt", "iterations", "password_sha"}
+
+// UserID returns the id of the user document of name
+func UserID(name string) string {
+	return userIDPrefix + name
+}
+
+// stripPasswords removes the password hashes from a user document
+func stripPasswords(doc map[string]interface{}) map[string]interface{} {
+	res := map[string]interface{}{}
+	for k, v := range doc {
+		if !contains(passwordFields, k) {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// getUser gets the user document of name, returning UserNotFoundError if it doesn't exist
+func getUser(c *Clippan, name string) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	found, err := helpers.GetOr404(c.client.DB(context.TODO(), usersDB), UserID(name), &doc)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, UserNotFoundError
+	}
+	return doc, nil
+}
+
+func putUser(c *Clippan, doc map[string]interface{}) error {
+	_, err := c.client.DB(context.TODO(), usersDB).Put(context.TODO(), doc["_id"].(string), doc)
+	return err
+}
+
+// userRoles returns the roles of a user document
+func userRoles(doc map[string]interface{}) []string {
+	roles := []string{}
+	list, _ := doc["roles"].([]interface{})
+	for _, r := range list {
+		if role, ok := r.(string); ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// askPassword asks for a new password, twice
+func askPassword(c *Clippan, name string) (string, error) {
+	password := c.Prompt.Password("New password for " + name + ": ")
+	if password == "" {
+		return "", EmptyPasswordError
+	}
+	if c.Prompt.Password("Repeat password: ") != password {
+		return "", PasswordMismatchError
+	}
+	return password, nil
+}
+
+// Users lists the users in _users
+func Users(c *Clippan, args []string) error {
+	var useJson, hashes bool
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.BoolVar(&useJson, "json", false, "Output the user documents as json")
+	fs.BoolVar(&hashes, "hashes", false, "Include the password hashes in the json output")
+	if fs.Parse(args[1:]) != nil {
+		return nil // help will have been printed
+	}
+
+	rows, err := c.client.DB(context.TODO(), usersDB).AllDocs(context.TODO(), kivik.Options{
+		"include_docs": true,
+		"startkey":     userIDPrefix,
+		"endkey":       userIDPrefix + "\ufff0",
+	})
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var docs []map[string]interface{}
+	for rows.Next() {
+		var doc map[string]interface{}
+		if err := rows.ScanDoc(&doc); err != nil {
+			return err
+		}
+		if !hashes {
+			doc = stripPasswords(doc)
+		}
+		docs = append(docs, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if useJson {
+		c.JSON(MustMarshal(docs))
+		return nil
+	}
+	c.Print("%-30s %s", "Name", "Roles")
+	for _, doc := range docs {
+		c.Print("%-30s %s", doc["name"], formatNames(userRoles(doc)))
+	}
+	return nil
+}
+
+// UserAdd creates a user, asking for its password
+func UserAdd(c *Clippan, args []string) error {
+	var roles string
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: useradd [flags] <name>\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&roles, "roles", "", "Comma separated roles of the user")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return UsageError
+	}
+	name := positional[0]
+
+	if _, err := getUser(c, name); err == nil {
+		return UserExistsError
+	} else if err != UserNotFoundError {
+		return err
+	}
+	password, err := askPassword(c, name)
+	if err != nil {
+		return err
+	}
+	userRoles := []string{}
+	if roles != "" {
+		userRoles = strings.Split(roles, ",")
+	}
+	doc := map[string]interface{}{
+		"_id":      UserID(name),
+		"name":     name,
+		"type":     "user",
+		"roles":    userRoles,
+		"password": password,
+	}
+	if err := putUser(c, doc); err != nil {
+		return err
+	}
+	c.Print("User %s created with roles %s", name, formatNames(userRoles))
+	return nil
+}
+
+// Passwd changes the password of a user
+func Passwd(c *Clippan, args []string) error {
+	if len(args) != 2 {
+		return UsageError
+	}
+	doc, err := getUser(c, args[1])
+	if err != nil {
+		return err
+	}
+	password, err := askPassword(c, args[1])
+	if err != nil {
+		return err
+	}
+	doc["password"] = password
+	if err := putUser(c, doc); err != nil {
+		return err
+	}
+	c.Print("Password of %s changed", args[1])
+	return nil
+}
+
+// UserDel deletes users
+func UserDel(c *Clippan, args []string) error {
+	force := false
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.BoolVar(&force, "f", false, "Force operation")
+	names, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(names) == 0 {
+		return UsageError
+	}
+
+	for _, name := range names {
+		doc, err := getUser(c, name)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		if !force {
+			in := c.Prompt.Input("Please type " + name + " to delete the user> ")
+			if in != name {
+				c.Print("Okay, not deleting")
+				continue
+			}
+		}
+		if _, err := c.client.DB(context.TODO(), usersDB).Delete(context.TODO(), UserID(name), doc["_rev"].(string)); err != nil {
+			return err
+		}
+		c.Print("User %s deleted", name)
+	}
+	return nil
+}
+
+// UserRoles adds (+role) or removes (-role) roles of a user. Flags can't be
+// used since -role looks like one
+func UserRoles(c *Clippan, args []string) error {
+	if len(args) < 3 {
+		c.Print("Usage: userroles <name> +role -role ...")
+		return UsageError
+	}
+	var add, remove []string
+	for _, arg := range args[2:] {
+		switch {
+		case strings.HasPrefix(arg, "+") && len(arg) > 1:
+			add = append(add, arg[1:])
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			remove = append(remove, arg[1:])
+		default:
+			return fmt.Errorf("%s: roles should start with + (add) or - (remove)", arg)
+		}
+	}
+
+	doc, err := getUser(c, args[1])
+	if err != nil {
+		return err
+	}
+	before := userRoles(doc)
+	after, added := addNames(append([]string{}, before...), add)
+	after, removed := removeNames(after, remove)
+	if !added && !removed {
+		c.Print("Nothing to change, roles: %s", formatNames(before))
+		return nil
+	}
+	if after == nil {
+		after = []string{} // _users requires an array
+	}
+	doc["roles"] = after
+	if err := putUser(c, doc); err != nil {
+		return err
+	}
+	c.Print("Roles of %s: %s -> %s", args[1], formatNames(before), formatNames(after))
+	return nil
+}
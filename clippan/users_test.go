@@ -0,0 +1,78 @@
+package clippan
+
+import (
+	"context"
+	"testing"
+
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripPasswords(t *testing.T) {
+	assert := assert.New(t)
+	doc := map[string]interface{}{"name": "bob", "derived_key": "abc", "salt": "def", "roles": []interface{}{}}
+	stripped := stripPasswords(doc)
+	assert.Equal(map[string]interface{}{"name": "bob", "roles": []interface{}{}}, stripped)
+	assert.Contains(doc, "salt")
+}
+
+func TestUsers(t *testing.T) {
+	DB := helpers.DBSession("test-users")
+	name := "clippan-test-user"
+
+	cleanUp := func(cdb *helpers.CouchDB) {
+		var doc map[string]interface{}
+		users := cdb.Client().DB(context.TODO(), usersDB)
+		if found, _ := helpers.GetOr404(users, UserID(name), &doc); found {
+			users.Delete(context.TODO(), UserID(name), doc["_rev"].(string)) // nolint: errcheck
+		}
+	}
+
+	t.Run("Test useradd in ro mode", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt().SetMockData("secret"))
+
+		c.Executer("useradd " + name)
+		assert.Len(t, printer.Errors, 1)
+	}))
+	t.Run("Test user management", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		cleanUp(cdb)
+		defer cleanUp(cdb)
+		printer := &TestPrinter{}
+		prompt := NewMockPrompt().SetMockData("secret")
+		c := NewTestClippan(cdb, true, printer, NewMockEditor(), prompt)
+
+		c.Executer("useradd -roles a,b " + name)
+		assert.Len(printer.Errors, 0)
+		assert.Len(prompt.Inputs, 2)
+
+		doc, err := getUser(c, name)
+		assert.NoError(err)
+		assert.Equal([]string{"a", "b"}, userRoles(doc))
+
+		c.Executer("useradd " + name)
+		assert.Len(printer.Errors, 1)
+
+		c.Executer("userroles " + name + " +c -a")
+		assert.Len(printer.Errors, 1)
+		doc, err = getUser(c, name)
+		assert.NoError(err)
+		assert.Equal([]string{"b", "c"}, userRoles(doc))
+
+		printer.JSONS = nil
+		c.Executer("users -json")
+		assert.Len(printer.Errors, 1)
+		assert.Len(printer.JSONS, 1)
+		assert.Contains(string(printer.JSONS[0]), name)
+		assert.NotContains(string(printer.JSONS[0]), "derived_key")
+
+		c.Executer("passwd " + name)
+		assert.Len(printer.Errors, 1)
+
+		c.Executer("userdel -f " + name)
+		assert.Len(printer.Errors, 1)
+		_, err = getUser(c, name)
+		assert.Equal(UserNotFoundError, err)
+	}))
+}
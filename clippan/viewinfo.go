@@ -0,0 +1,228 @@
+package clippan
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kivik/kivik/v4"
+	"github.com/iivvoo/clippan/helpers"
+)
+
+// ViewIndexInfo is the view_index part of a design document's _info
+type ViewIndexInfo struct {
+	Signature string `json:"signature"`
+	Language  string `json:"language"`
+	Sizes     struct {
+		File     int64 `json:"file"`
+		External int64 `json:"external"`
+		Active   int64 `json:"active"`
+	} `json:"sizes"`
+	UpdateSeq      interface{} `json:"update_seq"`
+	PurgeSeq       interface{} `json:"purge_seq"`
+	CompactRunning bool        `json:"compact_running"`
+	UpdaterRunning bool        `json:"updater_running"`
+	WaitingClients int         `json:"waiting_clients"`
+}
+
+// FormatSize formats a size in bytes for humans
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// shortSeq shortens (opaque) cluster sequences to their numeric part
+func shortSeq(seq interface{}) string {
+	if s, ok := seq.(string); ok {
+		return strings.SplitN(s, "-", 2)[0]
+	}
+	return fmt.Sprintf("%v", seq)
+}
+
+// GetViewInfo gets the view index info of a design document in database db
+func GetViewInfo(c *Clippan, db, id string) (*ViewIndexInfo, map[string]interface{}, error) {
+	path := "/" + url.PathEscape(db) + "/_design/" +
+		url.PathEscape(strings.TrimPrefix(id, "_design/")) + "/_info"
+
+	var raw map[string]interface{}
+	if err := c.Get(path, &raw); err != nil {
+		if StatusCode(err) == http.StatusNotFound {
+			return nil, nil, DesignDocNotFoundError
+		}
+		return nil, nil, err
+	}
+	info := &ViewIndexInfo{}
+	if err := json.Unmarshal(MustMarshal(raw["view_index"]), info); err != nil {
+		return nil, nil, err
+	}
+	return info, raw, nil
+}
+
+// indexerProgress sums the progress of the indexer tasks on a design document
+// over all shards. It returns false if there are none
+func indexerProgress(tasks []*ActiveTask, db, id string) (int, int, bool) {
+	var done, total int
+	found := false
+	for _, t := range tasks {
+		if t.Type != "indexer" || t.DesignDocument != id || t.DatabaseName() != db {
+			continue
+		}
+		found = true
+		done += t.ChangesDone
+		total += t.TotalChanges
+	}
+	return done, total, found
+}
+
+// seqNumber returns the numeric part of a (cluster) sequence, or -1 if it
+// has none
+func seqNumber(seq interface{}) int64 {
+	n, err := strconv.ParseInt(shortSeq(seq), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// triggerIndexBuild starts updating the index of a design document without
+// waiting for it, by querying one of its views with update=lazy. It returns
+// false if the design document has no views, so no index
+func triggerIndexBuild(c *Clippan, id string) (bool, error) {
+	var doc map[string]interface{}
+	found, err := helpers.GetOr404(c.database, id, &doc)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		return false, DesignDocNotFoundError
+	}
+	views, _ := doc["views"].(map[string]interface{})
+	for _, name := range sortedKeys(views) {
+		if name == "lib" {
+			continue
+		}
+		rows, err := c.database.Query(context.TODO(), id, name, kivik.Options{"limit": 0, "update": "lazy"})
+		if err != nil {
+			return false, err
+		}
+		return true, rows.Close()
+	}
+	return false, nil
+}
+
+// waitForIndex shows the progress of building the index of a design document until done.
+// A stale index is only updated when it's queried, so a build is triggered first
+func waitForIndex(c *Clippan, id string, interval time.Duration) error {
+	stats, err := c.database.Stats(context.TODO())
+	if err != nil {
+		return err
+	}
+	hasIndex, err := triggerIndexBuild(c, id)
+	if err != nil {
+		return err
+	}
+	if !hasIndex {
+		c.Print("%s has no views, there is no index to wait for", id)
+		return nil
+	}
+	target := seqNumber(stats.UpdateSeq)
+
+	started := time.Now()
+	shown := false
+	done, err := watch(interval, func() (bool, error) {
+		tasks, err := ActiveTasks(c)
+		if err != nil {
+			return false, err
+		}
+		changes, total, building := indexerProgress(tasks, c.database.Name(), id)
+		if !building {
+			info, _, err := GetViewInfo(c, c.database.Name(), id)
+			if err != nil {
+				return false, err
+			}
+			// the indexer may not have started yet right after triggering it
+			seq := seqNumber(info.UpdateSeq)
+			if !info.UpdaterRunning && (seq < 0 || seq >= target) {
+				return true, nil
+			}
+		}
+		fmt.Fprintf(os.Stderr, "\r%s %s", id, ProgressBar(changes, total, 40))
+		shown = true
+		return false, nil
+	})
+	if shown {
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+	if err != nil {
+		return err
+	}
+	if done {
+		c.Print("Index of %s is up to date (waited %s)", id, time.Since(started).Round(time.Second))
+	} else {
+		c.Print("Stopped waiting, the index is still being built")
+	}
+	return nil
+}
+
+// ViewInfo shows the index info of a design document, optionally waiting for
+// its index to be built
+func ViewInfo(c *Clippan, args []string) error {
+	var useJson, wait bool
+	var interval time.Duration
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: viewinfo [flags] <design-doc>\n")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&useJson, "json", false, "Output json")
+	fs.BoolVar(&wait, "wait", false, "Wait for the index to be built, showing its progress")
+	fs.DurationVar(&interval, "interval", time.Second, "How often to check the progress with -wait")
+	positional, err := ParseInterspersed(fs, args[1:])
+	if err != nil {
+		return nil // help will have been printed
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return UsageError
+	}
+	id := DesignDocID(positional[0])
+
+	if wait {
+		if err := waitForIndex(c, id, interval); err != nil {
+			return err
+		}
+	}
+
+	info, raw, err := GetViewInfo(c, c.database.Name(), id)
+	if err != nil {
+		return err
+	}
+	if useJson {
+		c.JSON(MustMarshal(raw))
+		return nil
+	}
+	c.Print("%s (signature %s, %s)", id, info.Signature, info.Language)
+	c.Print("  sizes:           file %s, active %s, external %s",
+		FormatSize(info.Sizes.File), FormatSize(info.Sizes.Active), FormatSize(info.Sizes.External))
+	c.Print("  update_seq:      %s", shortSeq(info.UpdateSeq))
+	c.Print("  purge_seq:       %s", shortSeq(info.PurgeSeq))
+	c.Print("  compact_running: %t", info.CompactRunning)
+	c.Print("  updater_running: %t", info.UpdaterRunning)
+	return nil
+}
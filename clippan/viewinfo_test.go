@@ -0,0 +1,42 @@
+package clippan
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/iivvoo/clippan/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViewInfo(t *testing.T) {
+	DB := helpers.DBSession("test-viewinfo")
+
+	t.Run("Test viewinfo", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		_, err := cdb.DB().Put(context.TODO(), "_design/test", testDesignDoc)
+		assert.NoError(err)
+		_, err = cdb.DB().Put(context.TODO(), "doc1", map[string]interface{}{"age": 42})
+		assert.NoError(err)
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("viewinfo -wait test")
+		assert.Len(printer.Errors, 0)
+		output := strings.Join(printer.Prints, "\n")
+		assert.Contains(output, "Index of _design/test is up to date")
+		assert.Contains(output, "updater_running: false")
+		// the index was never queried, waiting should have built it
+		assert.NotContains(output, "update_seq:      0\n")
+	}))
+	t.Run("Test viewinfo not found", DB(func(cdb *helpers.CouchDB, t *testing.T) {
+		assert := assert.New(t)
+		printer := &TestPrinter{}
+		c := NewTestClippan(cdb, false, printer, NewMockEditor(), NewMockPrompt())
+
+		c.Executer("use " + cdb.DB().Name())
+		c.Executer("viewinfo nope")
+		assert.Len(printer.Errors, 1)
+	}))
+}
@@ -7,10 +7,14 @@ require (
 	github.com/go-kivik/couchdb/v4 v4.0.0-20200710153906-95704eed8b30
 	github.com/go-kivik/kivik/v4 v4.0.0-20200710132642-db78c23bfa09
 	github.com/gobwas/glob v0.2.3
+	github.com/mattn/go-colorable v0.1.15 // indirect
 	github.com/mattn/go-runewidth v0.0.9 // indirect
 	github.com/mattn/go-shellwords v1.0.10
-	github.com/pkg/term v0.0.0-20200520122047-c3ffed290a03 // indirect
+	github.com/mattn/go-tty v0.0.3 // indirect
+	github.com/pkg/term v0.0.0-20200520122047-c3ffed290a03
+	github.com/robertkrimen/otto v0.0.0-20200922221731-ef014fd054ac
 	github.com/sirupsen/logrus v1.6.0
 	github.com/stretchr/testify v1.2.2
 	github.com/tidwall/pretty v1.0.1
+	gopkg.in/sourcemap.v1 v1.0.5 // indirect
 )